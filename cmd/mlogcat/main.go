@@ -0,0 +1,331 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+// Mlogcat filters and pretty-prints mlog formatted logs.
+//
+// Usage:
+//
+//	mlogcat [flags] [file ...]
+//
+// Records are read from the named files, or standard input if none are
+// given, and written to standard output either in a human readable form
+// or, with -json, as JSON lines. Filters may be combined; a record must
+// match all of them.
+//
+//	-level SEV    only records of severity SEV or more severe
+//	-sev LIST     only records with one of the comma separated severities
+//	-corr ID      only records with correlation id ID
+//	-pid PID      only records from process PID
+//	-name NAME    only records from process NAME
+//	-file GLOB    only records whose source file matches GLOB (e.g. db*.go)
+//	-since TIME   only records at or after TIME
+//	-until TIME   only records before TIME
+//	-color MODE   colorize by severity: auto, always or never
+//	-json         write JSON lines instead of text
+//	-other        also pass through lines that are not mlog records
+//
+// TIME is RFC3339, an mlog timestamp ("2006/01/02 15:04:05") or a
+// duration such as 15m meaning that long before now. Times are UTC.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lavaorg/lrt/mlog/parse"
+)
+
+var severities = []string{"ALARM", "ERROR", "STAT", "EVENT", "INFO", "DEBUG", "UNKNOWN"}
+
+// ANSI color per severity
+var colors = map[string]string{
+	"ALARM": "\x1b[1;31m",
+	"ERROR": "\x1b[31m",
+	"STAT":  "\x1b[36m",
+	"EVENT": "\x1b[35m",
+	"INFO":  "",
+	"DEBUG": "\x1b[90m",
+}
+
+const creset = "\x1b[0m"
+
+// filter holds the selection criteria; zero values match everything
+type filter struct {
+	sevs         map[string]bool
+	corr         string
+	pid          string
+	name         string
+	file         string
+	since, until time.Time
+}
+
+// options control how records are written
+type options struct {
+	color bool
+	json  bool
+	other bool
+}
+
+func main() {
+	var (
+		f     filter
+		o     options
+		err   error
+		level = flag.String("level", "", "only records of this severity or more severe")
+		sev   = flag.String("sev", "", "only records with one of these comma separated severities")
+		since = flag.String("since", "", "only records at or after this time")
+		until = flag.String("until", "", "only records before this time")
+		color = flag.String("color", "auto", "colorize output: auto, always or never")
+	)
+	flag.StringVar(&f.corr, "corr", "", "only records with this correlation id")
+	flag.StringVar(&f.pid, "pid", "", "only records from this process id")
+	flag.StringVar(&f.name, "name", "", "only records from this process name")
+	flag.StringVar(&f.file, "file", "", "only records whose source file matches this glob")
+	flag.BoolVar(&o.json, "json", false, "write JSON lines")
+	flag.BoolVar(&o.other, "other", false, "pass through lines that are not mlog records")
+	flag.Parse()
+
+	now := time.Now().UTC()
+	if f.sevs, err = parseSeverities(*level, *sev); err == nil {
+		if f.since, err = parseTime(*since, now); err == nil {
+			f.until, err = parseTime(*until, now)
+		}
+	}
+	if err == nil {
+		o.color, err = useColor(*color)
+	}
+	if err == nil && f.file != "" {
+		_, err = path.Match(f.file, "")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mlogcat:", err)
+		os.Exit(2)
+	}
+
+	status := 0
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, name := range files {
+		if err := catFile(name, &f, &o); err != nil {
+			fmt.Fprintln(os.Stderr, "mlogcat:", err)
+			status = 1
+		}
+	}
+	os.Exit(status)
+}
+
+// catFile copies the selected records of the named file, "-" is stdin
+func catFile(name string, f *filter, o *options) error {
+	if name == "-" {
+		return cat(os.Stdin, os.Stdout, f, o)
+	}
+	fd, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	return cat(fd, os.Stdout, f, o)
+}
+
+// cat copies the selected records from in to out
+func cat(in io.Reader, out io.Writer, f *filter, o *options) error {
+	d := parse.NewDecoder(in)
+	var b bytes.Buffer
+	for {
+		rec, err := d.Decode()
+		var serr *parse.SyntaxError
+		switch {
+		case err == io.EOF:
+			return nil
+		case errors.As(err, &serr):
+			if o.other {
+				b.Reset()
+				b.WriteString(serr.Text + "\n")
+				if _, err := out.Write(b.Bytes()); err != nil {
+					return err
+				}
+			}
+			continue
+		case err != nil:
+			return err
+		}
+		if !f.match(rec) {
+			continue
+		}
+		b.Reset()
+		if o.json {
+			writeJSON(&b, rec)
+		} else {
+			writeText(&b, rec, o.color)
+		}
+		if _, err := out.Write(b.Bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+// match reports whether rec passes every filter; short form records
+// lack a header and never match a header filter.
+func (f *filter) match(rec *parse.Record) bool {
+	if f.sevs != nil && !f.sevs[rec.Sev] {
+		return false
+	}
+	if f.corr != "" && rec.CorelationId != f.corr {
+		return false
+	}
+	if f.pid != "" && rec.Pid != f.pid {
+		return false
+	}
+	if f.name != "" && rec.Name != f.name {
+		return false
+	}
+	if f.file != "" {
+		if ok, _ := path.Match(f.file, rec.File); !ok {
+			return false
+		}
+	}
+	if !f.since.IsZero() && (rec.Short || rec.Time.Before(f.since)) {
+		return false
+	}
+	if !f.until.IsZero() && (rec.Short || !rec.Time.Before(f.until)) {
+		return false
+	}
+	return true
+}
+
+// writeText renders a record for people
+func writeText(b *bytes.Buffer, rec *parse.Record, color bool) {
+	if color {
+		b.WriteString(colors[rec.Sev])
+	}
+	if !rec.Short {
+		b.WriteString(rec.Time.Format("2006-01-02 15:04:05.000000") + " ")
+	}
+	fmt.Fprintf(b, "%-5s ", rec.Sev)
+	if !rec.Short {
+		fmt.Fprintf(b, "%s[%s] %s %s:%d ", rec.Name, rec.Pid, rec.CorelationId, rec.File, rec.Line)
+	}
+	b.WriteString(rec.Msg)
+	for _, fl := range rec.Fields {
+		v := fl.Value
+		if v == "" || strings.ContainsAny(v, " \"=\t\n") {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(" " + fl.Key + "=" + v)
+	}
+	if color && colors[rec.Sev] != "" {
+		b.WriteString(creset)
+	}
+	b.WriteByte('\n')
+}
+
+// jrecord matches the layout of the mlog JSON format
+type jrecord struct {
+	Sev    string            `json:"sev"`
+	Corr   string            `json:"corr,omitempty"`
+	Pid    string            `json:"pid,omitempty"`
+	Name   string            `json:"name,omitempty"`
+	File   string            `json:"file,omitempty"`
+	Line   int               `json:"line,omitempty"`
+	Time   string            `json:"time,omitempty"`
+	Msg    string            `json:"msg"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+func writeJSON(b *bytes.Buffer, rec *parse.Record) {
+	j := jrecord{Sev: rec.Sev, Msg: rec.Msg}
+	if !rec.Short {
+		j.Corr, j.Pid, j.Name = rec.CorelationId, rec.Pid, rec.Name
+		j.File, j.Line = rec.File, rec.Line
+		j.Time = rec.Time.Format(time.RFC3339Nano)
+	}
+	if len(rec.Fields) > 0 {
+		j.Fields = make(map[string]string, len(rec.Fields))
+		for _, fl := range rec.Fields {
+			j.Fields[fl.Key] = fl.Value
+		}
+	}
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(j)
+}
+
+// parseSeverities combines -level and -sev into a set; nil means all
+func parseSeverities(level, list string) (map[string]bool, error) {
+	if level == "" && list == "" {
+		return nil, nil
+	}
+	lset := map[string]bool{}
+	if level != "" {
+		i := index(strings.ToUpper(level))
+		if i < 0 {
+			return nil, fmt.Errorf("unknown severity %q", level)
+		}
+		for _, s := range severities[:i+1] {
+			lset[s] = true
+		}
+	}
+	if list == "" {
+		return lset, nil
+	}
+	set := map[string]bool{}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.ToUpper(strings.TrimSpace(s))
+		if index(s) < 0 {
+			return nil, fmt.Errorf("unknown severity %q", s)
+		}
+		if level == "" || lset[s] {
+			set[s] = true
+		}
+	}
+	return set, nil
+}
+
+func index(sev string) int {
+	for i, s := range severities {
+		if s == sev {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseTime accepts RFC3339, an mlog timestamp or a duration before now
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006/01/02 15:04:05.999999", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad time %q", s)
+}
+
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		fi, err := os.Stdout.Stat()
+		return err == nil && fi.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb", nil
+	}
+	return false, fmt.Errorf("bad color mode %q", mode)
+}
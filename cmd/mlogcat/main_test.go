@@ -0,0 +1,87 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const input = `*1|ERROR|c1|100|svc|db.go:10|2018/01/02 10:00:00.5|query failed {table=users}
+*1|INFO|c2|100|svc|http.go:20|2018/01/02 10:00:01|request
+not a record
+*1|DEBUG|c1|200|worker|db.go:30|2018/01/02 10:00:02|detail
+*1|ALARM|short form
+`
+
+func run(t *testing.T, f filter, o options) string {
+	var out bytes.Buffer
+	if err := cat(strings.NewReader(input), &out, &f, &o); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestFilter(t *testing.T) {
+	sevs, err := parseSeverities("info", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	since, _ := parseTime("2018/01/02 10:00:01", time.Now())
+	tests := []struct {
+		f    filter
+		want string // first letter of each selected severity
+	}{
+		{filter{}, "EIDA"},
+		{filter{sevs: sevs}, "EIA"},
+		{filter{corr: "c1"}, "ED"},
+		{filter{pid: "100"}, "EI"},
+		{filter{name: "worker"}, "D"},
+		{filter{file: "db*"}, "ED"},
+		{filter{since: since}, "ID"},
+		{filter{until: since}, "E"},
+	}
+	for _, tt := range tests {
+		var got string
+		for _, line := range strings.Split(run(t, tt.f, options{json: true}), "\n") {
+			if line != "" {
+				got += line[8:9] // first letter of "sev":"XXX"
+			}
+		}
+		if got != tt.want {
+			t.Errorf("%+v selected %q, want %q", tt.f, got, tt.want)
+		}
+	}
+}
+
+func TestOutput(t *testing.T) {
+	out := run(t, filter{corr: "c1"}, options{json: true})
+	want := `{"sev":"ERROR","corr":"c1","pid":"100","name":"svc","file":"db.go","line":10,"time":"2018-01-02T10:00:00.5Z","msg":"query failed","fields":{"table":"users"}}`
+	if !strings.HasPrefix(out, want+"\n") {
+		t.Errorf("json = %q, want %q", out, want)
+	}
+
+	out = run(t, filter{name: "svc", sevs: map[string]bool{"ERROR": true}}, options{color: true})
+	want = "\x1b[31m2018-01-02 10:00:00.500000 ERROR svc[100] c1 db.go:10 query failed table=users\x1b[0m\n"
+	if out != want {
+		t.Errorf("text = %q, want %q", out, want)
+	}
+
+	out = run(t, filter{sevs: map[string]bool{}}, options{other: true})
+	if out != "not a record\n" {
+		t.Errorf("other = %q", out)
+	}
+}
+
+func TestParseSeverities(t *testing.T) {
+	set, err := parseSeverities("error", "ERROR,INFO")
+	if err != nil || len(set) != 1 || !set["ERROR"] {
+		t.Errorf("got %v, %v", set, err)
+	}
+	if _, err := parseSeverities("", "LOUD"); err == nil {
+		t.Error("accepted unknown severity")
+	}
+}
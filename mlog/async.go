@@ -0,0 +1,284 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An AsyncPolicy decides what happens to a record when the queue of an
+// asynchronous logger is full. ALARM and ERROR records are never dropped;
+// they wait for room whatever the policy.
+type AsyncPolicy int
+
+const (
+	Block      AsyncPolicy = iota // wait for room in the queue
+	DropNewest                    // discard the record being logged
+	DropLowest                    // discard the least severe queued or new record
+)
+
+var policystr = []string{"block", "dropnewest", "droplowest"}
+
+// ParseAsyncPolicy converts block, dropnewest or droplowest to a policy.
+func ParseAsyncPolicy(s string) (AsyncPolicy, error) {
+	for i, n := range policystr {
+		if strings.EqualFold(s, n) {
+			return AsyncPolicy(i), nil
+		}
+	}
+	return Block, fmt.Errorf("mlog: unknown async policy %q", s)
+}
+
+// async is a bounded queue of records written by a single goroutine
+type async struct {
+	l       *Logger
+	size    int
+	policy  AsyncPolicy
+	mu      sync.Mutex
+	space   *sync.Cond // queue has room
+	idle    *sync.Cond // records written
+	queue   []entry
+	writing bool
+	closed  bool
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	dropped [UNKNOWN + 1]uint64 // atomic
+}
+
+// entry is a queued record; done, if not nil, is closed once it is written
+type entry struct {
+	r    *Record
+	done chan struct{}
+}
+
+// SetAsync moves writing to a background goroutine fed by a queue of
+// size records; when full the policy applies. ALARM and ERROR records
+// are written, after those queued before them, before the call logging
+// them returns, so they survive an immediate exit. Dropped records are
+// counted and reported as a STAT record every stats interval (if any
+// were dropped). A size of 0 returns to synchronous writing. Any
+// queued records are written before the change.
+func (l *Logger) SetAsync(size int, policy AsyncPolicy, stats time.Duration) {
+	var a *async
+	if size > 0 {
+		a = &async{
+			l:      l,
+			size:   size,
+			policy: policy,
+			wake:   make(chan struct{}, 1),
+			stop:   make(chan struct{}),
+			done:   make(chan struct{}),
+		}
+		a.space = sync.NewCond(&a.mu)
+		a.idle = sync.NewCond(&a.mu)
+		go a.run(stats)
+	}
+	if old := l.async.Swap(a); old != nil {
+		old.close()
+	}
+}
+
+// Flush waits until all queued records have been written.
+func (l *Logger) Flush() {
+	if a := l.async.Load(); a != nil {
+		a.flush()
+	}
+}
+
+// Close flushes and stops asynchronous writing and closes the output
+// streams and sinks, other than os.Stdout and os.Stderr, that are
+// io.Closers.
+func (l *Logger) Close() error {
+	if a := l.async.Swap(nil); a != nil {
+		a.close()
+	}
+	var err error
+	for _, o := range l.outputs() {
+		c, ok := o.(io.Closer)
+		if !ok || o == interface{}(os.Stdout) || o == interface{}(os.Stderr) {
+			continue
+		}
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Dropped returns the number of records discarded by the async policy.
+func (l *Logger) Dropped() uint64 {
+	var n uint64
+	if a := l.async.Load(); a != nil {
+		for i := range a.dropped {
+			n += atomic.LoadUint64(&a.dropped[i])
+		}
+	}
+	return n
+}
+
+// Flush waits until the default logger has written all queued records.
+func Flush() {
+	std.Flush()
+}
+
+// Close flushes and closes the default logger's outputs.
+func Close() error {
+	return std.Close()
+}
+
+// put queues r, applying the policy when the queue is full
+func (a *async) put(r *Record) {
+	a.mu.Lock()
+	for len(a.queue) >= a.size && !a.closed {
+		if r.Sev > ERROR && a.policy != Block {
+			if a.policy == DropLowest && a.evict(r.Sev) {
+				break
+			}
+			a.mu.Unlock()
+			atomic.AddUint64(&a.dropped[r.Sev], 1)
+			return
+		}
+		a.space.Wait()
+	}
+	if a.closed {
+		// raced with SetAsync or Close; write it ourselves
+		a.mu.Unlock()
+		a.l.write(r)
+		return
+	}
+	q := entry{r: r}
+	if r.Sev <= ERROR {
+		q.done = make(chan struct{})
+	}
+	a.queue = append(a.queue, q)
+	a.mu.Unlock()
+
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+
+	if q.done != nil {
+		// wait until written; the writer drains the queue before it stops
+		<-q.done
+	}
+}
+
+// evict removes the least severe queued record if it is less severe
+// than sev and neither an ALARM nor an ERROR
+func (a *async) evict(sev uint8) bool {
+	j := -1
+	for i, q := range a.queue {
+		if q.r.Sev > ERROR && q.r.Sev > sev && (j < 0 || q.r.Sev > a.queue[j].r.Sev) {
+			j = i
+		}
+	}
+	if j < 0 {
+		return false
+	}
+	atomic.AddUint64(&a.dropped[a.queue[j].r.Sev], 1)
+	a.queue = append(a.queue[:j], a.queue[j+1:]...)
+	return true
+}
+
+// run writes queued records until stopped
+func (a *async) run(stats time.Duration) {
+	defer close(a.done)
+	var tick <-chan time.Time
+	if stats > 0 {
+		t := time.NewTicker(stats)
+		defer t.Stop()
+		tick = t.C
+	}
+	var reported [UNKNOWN + 1]uint64
+	for {
+		select {
+		case <-a.wake:
+			a.drain()
+		case <-tick:
+			a.report(&reported)
+		case <-a.stop:
+			a.drain()
+			a.report(&reported)
+			return
+		}
+	}
+}
+
+// drain writes everything queued, in order
+func (a *async) drain() {
+	for {
+		a.mu.Lock()
+		batch := a.queue
+		if len(batch) == 0 {
+			a.writing = false
+			a.idle.Broadcast()
+			a.mu.Unlock()
+			return
+		}
+		a.queue = make([]entry, 0, a.size)
+		a.writing = true
+		a.space.Broadcast()
+		a.mu.Unlock()
+
+		for _, q := range batch {
+			a.l.write(q.r)
+			if q.done != nil {
+				close(q.done)
+			}
+		}
+	}
+}
+
+// report emits a STAT record of records dropped since the last report
+func (a *async) report(reported *[UNKNOWN + 1]uint64) {
+	var kv []interface{}
+	var total uint64
+	for sev := range a.dropped {
+		n := atomic.LoadUint64(&a.dropped[sev])
+		if d := n - reported[sev]; d > 0 {
+			kv = append(kv, strings.ToLower(sevstr[sev]), d)
+			total += d
+		}
+		reported[sev] = n
+	}
+	if total == 0 {
+		return
+	}
+	kv = append([]interface{}{"total", total}, kv...)
+	a.l.write(a.l.record(STAT, "mlog", 0, "mlog: dropped records", fields(kv)))
+}
+
+func (a *async) flush() {
+	a.mu.Lock()
+	for (len(a.queue) > 0 || a.writing) && !a.closed {
+		a.idle.Wait()
+	}
+	a.mu.Unlock()
+}
+
+// close writes what is queued and stops the writer; records put
+// after this are written by the caller
+func (a *async) close() {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		<-a.done
+		return
+	}
+	a.closed = true
+	a.space.Broadcast()
+	a.idle.Broadcast()
+	a.mu.Unlock()
+
+	close(a.stop)
+	<-a.done
+}
@@ -0,0 +1,183 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gate is a writer that blocks until opened
+type gate struct {
+	mu   sync.Mutex
+	open chan struct{}
+	buf  bytes.Buffer
+}
+
+func (g *gate) Write(p []byte) (int, error) {
+	<-g.open
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.buf.Write(p)
+}
+
+func (g *gate) String() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.buf.String()
+}
+
+func TestAsyncOrder(t *testing.T) {
+	g := &gate{open: make(chan struct{})}
+	close(g.open)
+	l := New(g, g)
+	l.SetSuppress(true)
+	l.SetAsync(4, Block, 0)
+	for i := 0; i < 100; i++ {
+		l.Info("%d", i)
+	}
+	l.Flush()
+
+	var want strings.Builder
+	for i := 0; i < 100; i++ {
+		want.WriteString("*2|7|INFO||||||" + strconv.Itoa(i) + "\n")
+	}
+	if got := g.String(); got != want.String() {
+		t.Errorf("output out of order or incomplete:\n%s", got)
+	}
+	l.Close()
+}
+
+func TestAsyncDropLowest(t *testing.T) {
+	g := &gate{open: make(chan struct{})}
+	l := New(g, g)
+	l.SetSuppress(true)
+	l.SetLevel(DEBUG)
+	l.SetAsync(3, DropLowest, time.Hour)
+
+	// the first record is taken by the writer, which then blocks
+	l.Info("taken")
+	for queued(l) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	l.Debug("evicted")
+	l.Info("kept")
+	l.Event("kept")
+	l.Stat("kept")  // evicts the DEBUG
+	l.Debug("lost") // less severe than all queued
+
+	done := make(chan struct{})
+	go func() {
+		l.Error("waits") // never dropped, waits for room
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("ERROR record did not wait for room")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if n := l.Dropped(); n != 2 {
+		t.Errorf("dropped = %d, want 2", n)
+	}
+
+	close(g.open)
+	<-done
+	l.Close()
+	want := "*2|7|INFO||||||taken\n*2|7|INFO||||||kept\n*2|7|EVENT||||||kept\n" +
+		"*2|7|STAT||||||kept\n*2|7|ERROR||||||waits\n" +
+		"*2|9|STAT||||||mlog: dropped records|total=2|debug=2\n"
+	if got := g.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func queued(l *Logger) int {
+	a := l.async.Load()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.queue)
+}
+
+func TestAsyncFatal(t *testing.T) {
+	if os.Getenv("MLOG_TEST_ASYNCFATAL") == "1" {
+		log.Fatalf("fatal %d", 3)
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestAsyncFatal$")
+	cmd.Env = append(os.Environ(), "MLOG_TEST_ASYNCFATAL=1", "LRT_MLOGASYNC=64")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err == nil {
+		t.Fatal("log.Fatalf did not exit")
+	}
+	if !strings.Contains(stderr.String(), "|ALARM|") || !strings.Contains(stderr.String(), "|fatal 3") {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+}
+
+func TestAsyncErrorWritten(t *testing.T) {
+	g := &gate{open: make(chan struct{})}
+	l := New(g, g)
+	l.SetSuppress(true)
+	l.SetAsync(8, Block, 0)
+	defer l.Close()
+
+	l.Info("queued")
+	done := make(chan struct{})
+	go func() {
+		l.Error("written")
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Error("Error returned before its record was written")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(g.open)
+	<-done
+	if got, want := g.String(), "*2|7|INFO||||||queued\n*2|7|ERROR||||||written\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestAsyncErrorEvicted(t *testing.T) {
+	g := &gate{open: make(chan struct{})}
+	l := New(g, g)
+	l.SetSuppress(true)
+	l.SetLevel(DEBUG)
+	l.SetAsync(3, DropLowest, 0)
+	defer l.Close()
+
+	l.Info("first")
+	for queued(l) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	done := make(chan struct{})
+	go func() {
+		l.Error("written")
+		close(done)
+	}()
+	for queued(l) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	// evicting a record queued after the ERROR must not count for it
+	l.Debug("a")
+	l.Debug("b")
+	l.Info("c")
+	g.open <- struct{}{} // write "first" only
+	select {
+	case <-done:
+		t.Error("Error returned before its record was written")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(g.open)
+	<-done
+}
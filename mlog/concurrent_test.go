@@ -0,0 +1,64 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentRecords writes multi-line records from many goroutines
+// through Info, Error and the log package into a writer that is not
+// itself safe for concurrent use. Version 1 writes a line per line of
+// the message, each record must still arrive whole. Run with -race.
+func TestConcurrentRecords(t *testing.T) {
+	const workers, records = 8, 200
+	var out bytes.Buffer
+	defer redirect(&out)()
+	std.SetWireVersion(1)
+
+	var wg sync.WaitGroup
+	for g := 0; g < workers; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < records; i++ {
+				switch i % 3 {
+				case 0:
+					Info("g%d i%d\nsecond\nthird", g, i)
+				case 1:
+					Error("g%d i%d\nsecond\nthird", g, i)
+				case 2:
+					log.Printf("g%d i%d\nsecond\nthird", g, i)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != workers*records*3 {
+		t.Fatalf("got %d lines, want %d", len(lines), workers*records*3)
+	}
+	next := make([]int, workers)
+	for n := 0; n < len(lines); n += 3 {
+		x := strings.SplitN(lines[n], "|", 3)
+		var g, i int
+		if _, err := fmt.Sscanf(x[2], "g%d i%d", &g, &i); err != nil {
+			t.Fatalf("line %d: %q does not start a record", n, lines[n])
+		}
+		if i != next[g] {
+			t.Fatalf("line %d: worker %d record %d, want %d", n, g, i, next[g])
+		}
+		next[g]++
+		head := x[0] + "|" + x[1] + "|"
+		if lines[n+1] != head+"second" || lines[n+2] != head+"third" {
+			t.Fatalf("line %d: record torn: %q", n, lines[n:n+3])
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"context"
+	"fmt"
+)
+
+// key of the correlation id in a context
+type ctxkey struct{}
+
+// WithCorelationId returns a copy of ctx carrying the correlation id.
+func WithCorelationId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxkey{}, id)
+}
+
+// CorelationIdFromContext returns the correlation id carried by ctx.
+func CorelationIdFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(ctxkey{}).(string)
+	return id, ok && id != ""
+}
+
+// WithContext returns a child Logger stamping records with the
+// correlation id carried by ctx; without one it returns l.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	id, ok := CorelationIdFromContext(ctx)
+	if !ok {
+		return l
+	}
+	return &Logger{core: l.core, fields: l.fields, corr: id}
+}
+
+// WithContext returns a child of the default Logger using the
+// correlation id carried by ctx.
+func WithContext(ctx context.Context) *Logger {
+	return std.WithContext(ctx)
+}
+
+// Emit debug message with the context's correlation id if debug is enabled
+func DebugCtx(ctx context.Context, template string, args ...interface{}) {
+	if std.possible(DEBUG) {
+		std.WithContext(ctx).output(1, DEBUG, fmt.Sprintf(template, args...))
+	}
+}
+
+// Emit an Event message with the context's correlation id
+func EventCtx(ctx context.Context, template string, args ...interface{}) {
+	std.WithContext(ctx).output(1, EVENT, fmt.Sprintf(template, args...))
+}
+
+// Emit an Info message with the context's correlation id
+func InfoCtx(ctx context.Context, template string, args ...interface{}) {
+	std.WithContext(ctx).output(1, INFO, fmt.Sprintf(template, args...))
+}
+
+// Emit a Stat message with the context's correlation id
+func StatCtx(ctx context.Context, template string, args ...interface{}) {
+	std.WithContext(ctx).output(1, STAT, fmt.Sprintf(template, args...))
+}
+
+// Emit an Error message with the context's correlation id
+func ErrorCtx(ctx context.Context, template string, args ...interface{}) {
+	std.WithContext(ctx).output(1, ERROR, fmt.Sprintf(template, args...))
+}
+
+// Emit an Alarm message with the context's correlation id
+func AlarmCtx(ctx context.Context, template string, args ...interface{}) {
+	std.WithContext(ctx).output(1, ALARM, fmt.Sprintf(template, args...))
+}
+
+// Emit debug message with the context's correlation id if the logger's
+// debug is enabled
+func (l *Logger) DebugCtx(ctx context.Context, template string, args ...interface{}) {
+	if l.possible(DEBUG) {
+		l.WithContext(ctx).output(1, DEBUG, fmt.Sprintf(template, args...))
+	}
+}
+
+// Emit an Event message with the context's correlation id
+func (l *Logger) EventCtx(ctx context.Context, template string, args ...interface{}) {
+	l.WithContext(ctx).output(1, EVENT, fmt.Sprintf(template, args...))
+}
+
+// Emit an Info message with the context's correlation id
+func (l *Logger) InfoCtx(ctx context.Context, template string, args ...interface{}) {
+	l.WithContext(ctx).output(1, INFO, fmt.Sprintf(template, args...))
+}
+
+// Emit a Stat message with the context's correlation id
+func (l *Logger) StatCtx(ctx context.Context, template string, args ...interface{}) {
+	l.WithContext(ctx).output(1, STAT, fmt.Sprintf(template, args...))
+}
+
+// Emit an Error message with the context's correlation id
+func (l *Logger) ErrorCtx(ctx context.Context, template string, args ...interface{}) {
+	l.WithContext(ctx).output(1, ERROR, fmt.Sprintf(template, args...))
+}
+
+// Emit an Alarm message with the context's correlation id
+func (l *Logger) AlarmCtx(ctx context.Context, template string, args ...interface{}) {
+	l.WithContext(ctx).output(1, ALARM, fmt.Sprintf(template, args...))
+}
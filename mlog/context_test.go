@@ -0,0 +1,36 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestContext(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	l.SetCorelationId("proc")
+
+	ctx := WithCorelationId(context.Background(), "req-1")
+	l.InfoCtx(ctx, "a %d", 1)
+	l.With("k", "v").WithContext(ctx).InfoKV("b")
+	l.InfoCtx(context.Background(), "c")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	want := []string{"|INFO|req-1|", "|INFO|req-1|", "|INFO|proc|"}
+	if len(lines) != len(want) {
+		t.Fatalf("output = %q", out.String())
+	}
+	for i, w := range want {
+		if !strings.Contains(lines[i], w) || !strings.Contains(lines[i], "|context_test.go:") {
+			t.Errorf("line %d = %q, want %q", i, lines[i], w)
+		}
+	}
+	if !strings.HasSuffix(lines[1], "|b|k=v") {
+		t.Errorf("fields lost: %q", lines[1])
+	}
+}
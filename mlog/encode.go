@@ -0,0 +1,343 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Output formats selectable with SetFormat or LRT_MLOGFORMAT
+const (
+	FormatMlog   = "mlog"   // the "*2|N|SEV|..." pipe separated format
+	FormatJSON   = "json"   // one JSON object per line
+	FormatLogfmt = "logfmt" // key=value pairs, one record per line
+)
+
+// A Record is a single log entry as handed to an encoder.
+type Record struct {
+	Sev          uint8
+	CorelationId string
+	Pid          string
+	Name         string // process name
+	File         string // short file name
+	Line         int
+	Time         time.Time
+	Msg          string
+	Fields       []Field
+	Stack        []Frame // captured calling stack, if enabled for Sev
+}
+
+// an encoder appends the rendered record to b
+type encoder func(b *bytes.Buffer, r *Record, o *encopts)
+
+// encoding options
+type encopts struct {
+	suppress  bool   // render only the severity, message and fields
+	wire      int    // version of the mlog format
+	multiline string // multi-line mode, "" for the version's default
+}
+
+// Multi-line message modes of the mlog format, selectable with
+// SetMultiline or LRT_MLOGMULTILINE. The JSON and logfmt formats always
+// write a single record.
+const (
+	MultilineSplit    = "split"    // a record per non-empty line; version 1 default
+	MultilineSingle   = "single"   // one escaped record; version 2 only and its default
+	MultilineContinue = "continue" // a header line then continuation lines
+)
+
+// mode returns the multi-line mode in effect
+func (o *encopts) mode() string {
+	switch {
+	case o.multiline != "":
+		return o.multiline
+	case o.wire == 1:
+		return MultilineSplit
+	}
+	return MultilineSingle
+}
+
+// sequence numbers linking continuation lines to their record
+var recseq uint64
+
+var encoders = map[string]encoder{
+	FormatMlog:   encodeMlog,
+	FormatJSON:   encodeJSON,
+	FormatLogfmt: encodeLogfmt,
+}
+
+// encodeMlog renders the pipe separated format of the selected version
+func encodeMlog(b *bytes.Buffer, r *Record, o *encopts) {
+	if o.wire == 1 {
+		encodeMlog1(b, r, o)
+	} else {
+		encodeMlog2(b, r, o)
+	}
+}
+
+// encodeMlog1 renders version 1. In split mode there is one line per
+// line of the message, each with the full header.
+func encodeMlog1(b *bytes.Buffer, r *Record, o *encopts) {
+
+	// fields are appended to each line of the message
+	var tail string
+	if fs := stackFields(r); len(fs) > 0 {
+		tail = " " + formatFields(fs)
+	}
+
+	if o.mode() == MultilineContinue {
+		if lines := msgLines(r.Msg); len(lines) > 1 {
+			seq := strconv.FormatUint(atomic.AddUint64(&recseq, 1), 10)
+			b.WriteString(header1(r, o, cmarker1+"#"+seq) + cseparator + lines[0] + tail + "\n")
+			for _, line := range lines[1:] {
+				b.WriteString(cmarker1 + "+" + seq + cseparator + line + "\n")
+			}
+			return
+		}
+	}
+
+	// create a structured log message to emit
+	message := header1(r, o, cmarker1)
+
+	// then split into individual lines (by CR)
+	n := 0
+	for _, line := range strings.Split(r.Msg, "\n") {
+		if line == "" {
+			continue
+		}
+		b.WriteString(message + cseparator + line + tail + "\n")
+		n++
+	}
+	if n == 0 && tail != "" {
+		b.WriteString(message + cseparator + tail[1:] + "\n")
+	}
+}
+
+// header1 returns the version 1 header starting with marker
+func header1(r *Record, o *encopts, marker string) string {
+	if o.suppress {
+		return strings.Join([]string{marker, sevstr[r.Sev]}, cseparator)
+	}
+	fileAndLine := strings.Join([]string{r.File, strconv.Itoa(r.Line)}, ":")
+	timestamp := r.Time.UTC().Format(ctmformat)
+	return strings.Join([]string{
+		marker, sevstr[r.Sev], r.CorelationId, r.Pid, r.Name, fileAndLine, timestamp,
+	}, cseparator)
+}
+
+// encodeMlog2 renders version 2: lines whose fields are escaped and
+// counted. The short form leaves the header fields empty.
+func encodeMlog2(b *bytes.Buffer, r *Record, o *encopts) {
+	switch o.mode() {
+	case MultilineSplit:
+		n := 0
+		for _, line := range strings.Split(r.Msg, "\n") {
+			if line != "" {
+				record2(b, r, o, cmarker, line)
+				n++
+			}
+		}
+		if n == 0 && len(stackFields(r)) > 0 {
+			record2(b, r, o, cmarker, "")
+		}
+		return
+	case MultilineContinue:
+		if lines := msgLines(r.Msg); len(lines) > 1 {
+			seq := strconv.FormatUint(atomic.AddUint64(&recseq, 1), 10)
+			record2(b, r, o, cmarker+"#"+seq, lines[0])
+			for _, line := range lines[1:] {
+				b.WriteString(cmarker + "+" + seq + cseparator + escape(line) + "\n")
+			}
+			return
+		}
+	}
+	record2(b, r, o, cmarker, r.Msg)
+}
+
+// record2 writes one version 2 line carrying msg
+func record2(b *bytes.Buffer, r *Record, o *encopts, marker, msg string) {
+	fs := stackFields(r)
+	x := make([]string, 0, cfields2+len(fs))
+	x = append(x, sevstr[r.Sev])
+	if o.suppress {
+		x = append(x, "", "", "", "", "")
+	} else {
+		x = append(x,
+			escape(r.CorelationId),
+			escape(r.Pid),
+			escape(r.Name),
+			escape(r.File)+":"+strconv.Itoa(r.Line),
+			r.Time.UTC().Format(ctmformat))
+	}
+	x = append(x, escape(msg))
+	for _, f := range fs {
+		x = append(x, escapeKey(f.Key)+"="+escape(fmt.Sprint(f.Value)))
+	}
+	b.WriteString(marker + cseparator + strconv.Itoa(len(x)) + cseparator)
+	b.WriteString(strings.Join(x, cseparator))
+	b.WriteByte('\n')
+}
+
+// msgLines splits a message into lines ignoring trailing newlines
+func msgLines(m string) []string {
+	return strings.Split(strings.TrimRight(m, "\n"), "\n")
+}
+
+// escape makes s safe as a version 2 field: backslash is doubled,
+// newline, return and tab become \n \r \t, and the separator and other
+// control characters become \xHH. The result never contains '|'.
+func escape(s string) string {
+	i := 0
+	for ; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c == 0x7f || c == '|' || c == '\\' {
+			break
+		}
+	}
+	if i == len(s) {
+		return s
+	}
+	var b strings.Builder
+	b.WriteString(s[:i])
+	for ; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\r':
+			b.WriteString(`\r`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f || c == '|':
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// escapeKey also escapes '=' so the first '=' ends a field's key
+func escapeKey(k string) string {
+	if k == "" {
+		return "_"
+	}
+	return strings.ReplaceAll(escape(k), "=", `\x3d`)
+}
+
+// encodeJSON renders the record as a single line JSON object
+func encodeJSON(b *bytes.Buffer, r *Record, o *encopts) {
+	b.WriteString(`{"sev":`)
+	jsonString(b, sevstr[r.Sev])
+	if !o.suppress {
+		b.WriteString(`,"corr":`)
+		jsonString(b, r.CorelationId)
+		b.WriteString(`,"pid":`)
+		jsonString(b, r.Pid)
+		b.WriteString(`,"name":`)
+		jsonString(b, r.Name)
+		b.WriteString(`,"file":`)
+		jsonString(b, r.File)
+		b.WriteString(`,"line":`)
+		b.WriteString(strconv.Itoa(r.Line))
+		b.WriteString(`,"time":`)
+		jsonString(b, r.Time.UTC().Format(time.RFC3339Nano))
+	}
+	b.WriteString(`,"msg":`)
+	jsonString(b, r.Msg)
+	if len(r.Fields) > 0 {
+		b.WriteString(`,"fields":{`)
+		for i, f := range r.Fields {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			jsonString(b, f.Key)
+			b.WriteByte(':')
+			jsonValue(b, f.Value)
+		}
+		b.WriteByte('}')
+	}
+	if len(r.Stack) > 0 {
+		b.WriteString(`,"stack":`)
+		jsonMarshal(b, r.Stack)
+	}
+	b.WriteString("}\n")
+}
+
+// encodeLogfmt renders the record as a single line of key=value pairs,
+// the record's fields following the header keys.
+func encodeLogfmt(b *bytes.Buffer, r *Record, o *encopts) {
+	b.WriteString("sev=" + sevstr[r.Sev])
+	if !o.suppress {
+		b.WriteString(" corr=" + fieldValue(r.CorelationId))
+		b.WriteString(" pid=" + fieldValue(r.Pid))
+		b.WriteString(" name=" + fieldValue(r.Name))
+		b.WriteString(" file=" + fieldValue(r.File))
+		b.WriteString(" line=" + strconv.Itoa(r.Line))
+		b.WriteString(" time=" + r.Time.UTC().Format(time.RFC3339Nano))
+	}
+	b.WriteString(" msg=" + fieldValue(r.Msg))
+	for _, f := range r.Fields {
+		b.WriteString(" " + logfmtKey(f.Key) + "=" + fieldValue(fmt.Sprint(f.Value)))
+	}
+	if len(r.Stack) > 0 {
+		b.WriteString(" " + cstackkey + "=" + fieldValue(formatStack(r.Stack)))
+	}
+	b.WriteByte('\n')
+}
+
+// keys the logfmt encoder writes itself
+var logfmtOwn = map[string]bool{
+	"sev": true, "corr": true, "pid": true, "name": true, "file": true,
+	"line": true, "time": true, "msg": true, cstackkey: true,
+}
+
+// logfmtKey sanitizes a field key, prefixing one that would repeat a
+// key of the record itself with f_
+func logfmtKey(k string) string {
+	k = fieldKey(k)
+	if logfmtOwn[k] {
+		k = "f_" + k
+	}
+	return k
+}
+
+func jsonString(b *bytes.Buffer, s string) {
+	jsonMarshal(b, s)
+}
+
+// jsonMarshal appends v without html escaping, reporting failures
+func jsonMarshal(b *bytes.Buffer, v interface{}) error {
+	n := b.Len()
+	enc := json.NewEncoder(b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		b.Truncate(n)
+		return err
+	}
+	b.Truncate(b.Len() - 1) // drop the Encoder's newline
+	return nil
+}
+
+// jsonValue renders errors and durations as strings and anything
+// that cannot be marshaled with its fmt representation.
+func jsonValue(b *bytes.Buffer, v interface{}) {
+	switch x := v.(type) {
+	case error:
+		jsonString(b, x.Error())
+		return
+	case time.Duration:
+		jsonString(b, x.String())
+		return
+	}
+	if jsonMarshal(b, v) != nil {
+		jsonString(b, fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONFormat(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	if err := l.SetFormat("xml"); err == nil {
+		t.Error("SetFormat accepted an unknown format")
+	}
+	if err := l.SetFormat(FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	l.SetCorelationId("c1")
+	l.InfoKV("two\nlines <b>", "n", 3, "d", 1500*time.Millisecond, "err", errors.New("boom"))
+
+	var rec struct {
+		Sev, Corr, Pid, Name, File, Time, Msg string
+		Line                                  int
+		Fields                                map[string]interface{}
+	}
+	if err := json.Unmarshal(out.Bytes(), &rec); err != nil {
+		t.Fatalf("%v: %q", err, out.String())
+	}
+	if rec.Sev != "INFO" || rec.Corr != "c1" || rec.Pid != pid || rec.File != "encode_test.go" || rec.Line == 0 {
+		t.Errorf("bad header %+v", rec)
+	}
+	if rec.Msg != "two\nlines <b>" {
+		t.Errorf("msg = %q", rec.Msg)
+	}
+	if rec.Fields["n"] != 3.0 || rec.Fields["d"] != "1.5s" || rec.Fields["err"] != "boom" {
+		t.Errorf("fields = %v", rec.Fields)
+	}
+
+	out.Reset()
+	l.SetSuppress(true)
+	l.ErrorKV("x", "k", "v")
+	if got, want := out.String(), `{"sev":"ERROR","msg":"x","fields":{"k":"v"}}`+"\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestLogfmtFormat(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	if err := l.SetFormat(FormatLogfmt); err != nil {
+		t.Fatal(err)
+	}
+	l.SetCorelationId("c 1")
+	l.StatKV("a=b\nc", "rows", 3, "path", "/x y", "empty", "")
+
+	got := out.String()
+	wantPrefix := `sev=STAT corr="c 1" pid=` + pid + " name=" + fieldValue(name) + " file=encode_test.go line="
+	wantSuffix := ` msg="a=b\nc" rows=3 path="/x y" empty=""` + "\n"
+	if !strings.HasPrefix(got, wantPrefix) || !strings.HasSuffix(got, wantSuffix) {
+		t.Errorf("output = %q, want %q...%q", got, wantPrefix, wantSuffix)
+	}
+
+	out.Reset()
+	l.SetSuppress(true)
+	l.Info("hello")
+	if got, want := out.String(), "sev=INFO msg=hello\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	l.InfoKV("hello", "msg", "other", "sev", 1, "stack", "x")
+	if got, want := out.String(), "sev=INFO msg=hello f_msg=other f_sev=1 f_stack=x\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestMlogV2Format(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"plain text", "plain text"},
+		{"a|b", `a\x7cb`},
+		{"two\nlines\r\tx", `two\nlines\r\tx`},
+		{`back\slash`, `back\\slash`},
+		{"bell\a", `bell\x07`},
+	}
+	for _, tt := range tests {
+		if got := escape(tt.in); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	var out bytes.Buffer
+	l := New(&out, &out)
+	l.SetSuppress(true)
+	l.InfoKV("m|1", "a=b", "c|d", "n", 2)
+	if got, want := out.String(), `*2|9|INFO||||||m\x7c1|a\x3db=c\x7cd|n=2`+"\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestMultiline(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	l.SetSuppress(true)
+
+	l.SetWireVersion(1)
+	if err := l.SetMultiline(MultilineSingle); err == nil {
+		t.Error("v1 accepted single mode")
+	}
+	l.InfoKV("a\n\nb", "n", 1)
+	if got, want := out.String(), "*1|INFO|a {n=1}\n*1|INFO|b {n=1}\n"; got != want {
+		t.Errorf("v1 split = %q, want %q", got, want)
+	}
+
+	l.SetWireVersion(2)
+	out.Reset()
+	l.Info("a\nb")
+	if got, want := out.String(), `*2|7|INFO||||||a\nb`+"\n"; got != want {
+		t.Errorf("v2 single = %q, want %q", got, want)
+	}
+
+	l.SetMultiline(MultilineContinue)
+	out.Reset()
+	l.InfoKV("a|1\n\nb\n", "n", 1)
+	lines := strings.Split(out.String(), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "*2#") {
+		t.Fatalf("v2 continue = %q", out.String())
+	}
+	seq := lines[0][3:strings.IndexByte(lines[0], '|')]
+	want := []string{
+		"*2#" + seq + `|8|INFO||||||a\x7c1|n=1`,
+		"*2+" + seq + "|",
+		"*2+" + seq + "|b",
+		"",
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+}
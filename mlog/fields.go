@@ -0,0 +1,176 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// value used when a key is given without a value
+const cmissing = "!MISSING"
+
+// A Field is a key/value pair carried by a record.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// fields converts an alternating list of keys and values into Fields.
+// A key that is not a string is formatted with fmt.Sprint.
+func fields(kv []interface{}) []Field {
+	if len(kv) == 0 {
+		return nil
+	}
+	fs := make([]Field, 0, (len(kv)+1)/2)
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		var val interface{} = cmissing
+		if i+1 < len(kv) {
+			val = kv[i+1]
+		}
+		fs = append(fs, Field{Key: key, Value: val})
+	}
+	return fs
+}
+
+// With returns a child Logger sharing l's settings that adds the given
+// key/value pairs to every record it emits.
+func (l *Logger) With(kv ...interface{}) *Logger {
+	return &Logger{
+		core:   l.core,
+		fields: append(append([]Field(nil), l.fields...), fields(kv)...),
+		corr:   l.corr,
+	}
+}
+
+// With returns a child of the default Logger carrying the given fields.
+func With(kv ...interface{}) *Logger {
+	return std.With(kv...)
+}
+
+// Emit debug message with fields if debug is enabled
+func DebugKV(m string, kv ...interface{}) {
+	std.outputKV(1, DEBUG, m, kv)
+}
+
+// Emit an Event message with fields
+func EventKV(m string, kv ...interface{}) {
+	std.outputKV(1, EVENT, m, kv)
+}
+
+// Emit an Info message with fields
+func InfoKV(m string, kv ...interface{}) {
+	std.outputKV(1, INFO, m, kv)
+}
+
+// Emit a Stat message with fields
+func StatKV(m string, kv ...interface{}) {
+	std.outputKV(1, STAT, m, kv)
+}
+
+// Emit an Error message with fields
+func ErrorKV(m string, kv ...interface{}) {
+	std.outputKV(1, ERROR, m, kv)
+}
+
+// Emit an Alarm message with fields
+func AlarmKV(m string, kv ...interface{}) {
+	std.outputKV(1, ALARM, m, kv)
+}
+
+// Emit a custom type, message and fields
+// lev is file:line in call stack to emit
+func EmitKV(lev int, severity uint8, m string, kv ...interface{}) {
+	std.outputKV(lev+1, severity, m, kv)
+}
+
+// Emit debug message with fields if the logger's debug is enabled
+func (l *Logger) DebugKV(m string, kv ...interface{}) {
+	l.outputKV(1, DEBUG, m, kv)
+}
+
+// Emit an Event message with fields
+func (l *Logger) EventKV(m string, kv ...interface{}) {
+	l.outputKV(1, EVENT, m, kv)
+}
+
+// Emit an Info message with fields
+func (l *Logger) InfoKV(m string, kv ...interface{}) {
+	l.outputKV(1, INFO, m, kv)
+}
+
+// Emit a Stat message with fields
+func (l *Logger) StatKV(m string, kv ...interface{}) {
+	l.outputKV(1, STAT, m, kv)
+}
+
+// Emit an Error message with fields
+func (l *Logger) ErrorKV(m string, kv ...interface{}) {
+	l.outputKV(1, ERROR, m, kv)
+}
+
+// Emit an Alarm message with fields
+func (l *Logger) AlarmKV(m string, kv ...interface{}) {
+	l.outputKV(1, ALARM, m, kv)
+}
+
+// Emit a custom type, message and fields
+// lev is file:line in call stack to emit
+func (l *Logger) EmitKV(lev int, severity uint8, m string, kv ...interface{}) {
+	l.outputKV(lev+1, severity, m, kv)
+}
+
+// formatFields renders fields as a brace enclosed list of key=value
+// pairs separated by a space, e.g. {user=42 path="/a b"}. Keys are
+// stripped of characters that would make the list ambiguous and values
+// are quoted (Go syntax) when they contain such characters.
+func formatFields(fs []Field) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, f := range fs {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(fieldKey(f.Key))
+		b.WriteByte('=')
+		b.WriteString(fieldValue(fmt.Sprint(f.Value)))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// fieldKey replaces unsafe characters in a key by '_'
+func fieldKey(k string) string {
+	if k == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if needsQuote(r) || r == '"' {
+			return '_'
+		}
+		return r
+	}, k)
+}
+
+// fieldValue quotes v if it is empty or contains unsafe characters
+func fieldValue(v string) string {
+	if v == "" || strings.IndexFunc(v, needsQuote) >= 0 || strings.HasPrefix(v, `"`) {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+func needsQuote(r rune) bool {
+	switch r {
+	case ' ', '=', '{', '}', '|', '\\':
+		return true
+	}
+	return !unicode.IsPrint(r)
+}
@@ -0,0 +1,251 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// layout of the timestamp appended to rotated file names
+const crotateformat = "20060102T150405.000"
+
+// A RotatingFile is an io.WriteCloser appending to the file at Path.
+// The file is rotated, renamed to Path-TIMESTAMP, when a write would
+// take it past MaxSize bytes or it is older than MaxAge. A zero limit
+// disables that check. The age of a file carried over from an earlier
+// run is counted from the newest rotated file's timestamp, when it was
+// started, or else from when it is opened. At most MaxBackups rotated
+// files are kept (0 keeps all), optionally gzip compressed. The zero
+// value with a Path is ready for use; the file is opened on the first
+// write.
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	Compress   bool
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+	mill   sync.Mutex     // serializes compress and prune
+	wg     sync.WaitGroup // running mill goroutines
+}
+
+// Write appends p to the file, rotating first if needed.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.due(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate closes the current file, renames it and opens a new one.
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rotate()
+}
+
+// Reopen closes and reopens Path, for use after an external tool such
+// as logrotate has moved the file (typically on SIGHUP).
+func (r *RotatingFile) Reopen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+	return r.open()
+}
+
+// Sync commits the current file to stable storage.
+func (r *RotatingFile) Sync() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Sync()
+}
+
+// Close closes the file and waits for pending compression.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.f != nil {
+		err = r.f.Close()
+		r.f = nil
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return err
+}
+
+// Reopen reopens the logger's outputs that support it, such as the
+// RotatingFiles of LRT_MLOGFILE and file: routes, e.g. on SIGHUP after
+// logrotate has moved them.
+func (l *Logger) Reopen() error {
+	var err error
+	for _, o := range l.outputs() {
+		if r, ok := o.(interface{ Reopen() error }); ok {
+			if rerr := r.Reopen(); err == nil {
+				err = rerr
+			}
+		}
+	}
+	return err
+}
+
+// Reopen reopens the default logger's outputs that support it.
+func Reopen() error {
+	return std.Reopen()
+}
+
+// due reports whether writing n more bytes requires a rotation
+func (r *RotatingFile) due(n int64) bool {
+	if r.MaxSize > 0 && r.size > 0 && r.size+n > r.MaxSize {
+		return true
+	}
+	return r.MaxAge > 0 && time.Since(r.opened) >= r.MaxAge
+}
+
+func (r *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(r.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size, r.opened = f, fi.Size(), time.Now()
+	if r.size > 0 {
+		if t, ok := rotated(r.Path); ok && t.Before(r.opened) {
+			r.opened = t
+		}
+	}
+	return nil
+}
+
+// rotated returns the time path was last rotated by a RotatingFile,
+// from the name of its newest backup
+func rotated(path string) (time.Time, bool) {
+	old := backups(path)
+	for i := len(old) - 1; i >= 0; i-- {
+		ts := strings.TrimPrefix(old[i], path+"-")
+		if len(ts) < len(crotateformat) {
+			continue
+		}
+		if t, err := time.Parse(crotateformat, ts[:len(crotateformat)]); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (r *RotatingFile) rotate() error {
+	if r.f != nil {
+		r.f.Close()
+		r.f = nil
+	}
+	backup := r.Path + "-" + time.Now().UTC().Format(crotateformat)
+	for i := 1; exists(backup) || exists(backup+".gz"); i++ {
+		backup = r.Path + "-" + time.Now().UTC().Format(crotateformat) + "." + strconv.Itoa(i)
+	}
+	if err := os.Rename(r.Path, backup); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.wg.Add(1)
+	go r.tidy(backup)
+	return nil
+}
+
+// tidy compresses a freshly rotated file and prunes old backups
+func (r *RotatingFile) tidy(backup string) {
+	defer r.wg.Done()
+	r.mill.Lock()
+	defer r.mill.Unlock()
+
+	if r.Compress {
+		if err := compress(backup); err == nil {
+			os.Remove(backup)
+		}
+	}
+	if r.MaxBackups <= 0 {
+		return
+	}
+	old := backups(r.Path)
+	for len(old) > r.MaxBackups {
+		os.Remove(old[0])
+		old = old[1:]
+	}
+}
+
+func compress(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out.Name())
+	}
+	return err
+}
+
+func exists(name string) bool {
+	_, err := os.Lstat(name)
+	return err == nil
+}
+
+// backups returns the rotated files of path, oldest first; the
+// timestamp suffix makes name order the age order
+func backups(path string) []string {
+	old, _ := filepath.Glob(path + "-*")
+	sort.Slice(old, func(i, j int) bool {
+		return strings.TrimSuffix(old[i], ".gz") < strings.TrimSuffix(old[j], ".gz")
+	})
+	return old
+}
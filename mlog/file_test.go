@@ -0,0 +1,131 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "app.log")
+	f := &RotatingFile{Path: path, MaxSize: 40, MaxBackups: 2, Compress: true}
+	l := New(f, f)
+	l.SetSuppress(true)
+
+	for i := 0; i < 5; i++ {
+		l.Info("a record of twenty") // 35 bytes with the header
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := backups(path)
+	if len(old) != 2 {
+		t.Fatalf("backups = %v, want 2", old)
+	}
+	for _, name := range old {
+		if !strings.HasSuffix(name, ".gz") {
+			t.Fatalf("%s not compressed", name)
+		}
+		fd, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(zr)
+		fd.Close()
+		if string(b) != "*2|7|INFO||||||a record of twenty\n" {
+			t.Errorf("%s holds %q", name, b)
+		}
+	}
+	b, _ := os.ReadFile(path)
+	if string(b) != "*2|7|INFO||||||a record of twenty\n" {
+		t.Errorf("current file holds %q", b)
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	f := &RotatingFile{Path: path}
+	defer f.Close()
+
+	f.Write([]byte("one\n"))
+	os.Rename(path, path+".moved")
+	f.Write([]byte("two\n"))
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("three\n"))
+
+	moved, _ := os.ReadFile(path + ".moved")
+	cur, _ := os.ReadFile(path)
+	if string(moved) != "one\ntwo\n" || string(cur) != "three\n" {
+		t.Errorf("moved = %q, current = %q", moved, cur)
+	}
+}
+
+func TestRotatingFileRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	os.WriteFile(path, []byte("old\n"), 0644)
+
+	// a file without backups starts its age when opened
+	f := &RotatingFile{Path: path, MaxAge: time.Hour}
+	f.Write([]byte("one\n"))
+	f.Close()
+	if b, _ := os.ReadFile(path); string(b) != "old\none\n" {
+		t.Fatalf("current file holds %q", b)
+	}
+
+	// one rotated two hours ago is due
+	started := time.Now().Add(-2 * time.Hour).UTC().Format(crotateformat)
+	os.WriteFile(path+"-"+started+".gz", nil, 0644)
+	f = &RotatingFile{Path: path, MaxAge: time.Hour}
+	f.Write([]byte("two\n"))
+	f.Close()
+	if b, _ := os.ReadFile(path); string(b) != "two\n" {
+		t.Errorf("current file holds %q", b)
+	}
+	if old := backups(path); len(old) != 2 {
+		t.Errorf("backups = %v, want 2", old)
+	}
+}
+
+func TestLoggerReopen(t *testing.T) {
+	dir := t.TempDir()
+	f := &RotatingFile{Path: filepath.Join(dir, "out.log")}
+	l := New(f, f)
+	defer l.Close()
+	l.SetSuppress(true)
+	if err := l.SetRoutes("STAT=file:" + filepath.Join(dir, "stat.log")); err != nil {
+		t.Fatal(err)
+	}
+
+	l.Info("one")
+	l.Stat("one")
+	for _, name := range []string{"out.log", "stat.log"} {
+		os.Rename(filepath.Join(dir, name), filepath.Join(dir, name+".1"))
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	l.Info("two")
+	l.Stat("two")
+
+	for _, name := range []string{"out.log", "stat.log"} {
+		moved, _ := os.ReadFile(filepath.Join(dir, name+".1"))
+		cur, _ := os.ReadFile(filepath.Join(dir, name))
+		if !strings.HasSuffix(string(moved), "|one\n") || !strings.HasSuffix(string(cur), "|two\n") {
+			t.Errorf("%s: moved = %q, current = %q", name, moved, cur)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// here returns the line it is called from
+func here() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
+
+// wrapped logs through Output attributing the record to its caller
+func wrapped(m string) {
+	log.Output(2, m)
+}
+
+func TestGologCaller(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	lg := log.New(l.Writer(), "", 0)
+	lf := log.New(l.Writer(), "", log.Lshortfile)
+	lp := log.New(l.Writer(), "app: ", log.Llongfile)
+	lm := log.New(l.Writer(), "app: ", log.Lshortfile|log.Lmsgprefix)
+
+	saved := std.core
+	std.core = l.core
+	defer func() { std.core = saved }()
+
+	var want []string
+	expect := func(sev string, line int, msg string) {
+		want = append(want, "|"+sev+"|", "|golog_test.go:"+strconv.Itoa(line)+"|", "|"+msg)
+	}
+	log.Print("print")
+	expect("INFO", here()-1, "print")
+	log.Printf("printf %d", 1)
+	expect("INFO", here()-1, "printf 1")
+	log.Default().Println("default")
+	expect("INFO", here()-1, "default")
+	wrapped("wrapped")
+	expect("INFO", here()-1, "wrapped")
+	lg.Print("new")
+	expect("INFO", here()-1, "new")
+	lf.Print("shortfile")
+	expect("INFO", here()-1, "shortfile")
+	lp.Print("prefix")
+	expect("INFO", here()-1, "app: prefix")
+	lm.Print("msgprefix")
+	expect("INFO", here()-1, "app: msgprefix")
+	log.SetPrefix("std: ")
+	log.Print("setprefix")
+	log.SetPrefix("")
+	expect("INFO", here()-2, "std: setprefix")
+	func() {
+		defer func() { recover() }()
+		lg.Panicf("panic %d", 2)
+	}()
+	expect("ALARM", here()-2, "panic 2")
+	func() {
+		defer func() { recover() }()
+		log.Panicln("panicln")
+	}()
+	expect("ALARM", here()-2, "panicln")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(want)/3 {
+		t.Fatalf("output = %q", out.String())
+	}
+	for i, line := range lines {
+		for _, w := range want[3*i : 3*i+3] {
+			if !strings.Contains(line, w) {
+				t.Errorf("line %d = %q, want %q", i, line, w)
+			}
+		}
+	}
+}
+
+func TestGologFatal(t *testing.T) {
+	if os.Getenv("MLOG_TEST_FATAL") == "1" {
+		log.New(std.Writer(), "", 0).Fatalf("fatal %d", 3)
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestGologFatal$")
+	cmd.Env = append(os.Environ(), "MLOG_TEST_FATAL=1")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err == nil {
+		t.Fatal("log.Fatalf did not exit")
+	}
+	if !strings.Contains(stderr.String(), "|ALARM|") || !strings.Contains(stderr.String(), "|golog_test.go:") ||
+		!strings.Contains(stderr.String(), "|fatal 3") {
+		t.Errorf("stderr = %q", stderr.String())
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// path of the journald native protocol socket
+const cjournalpath = "/run/systemd/journal/socket"
+
+// fields the sink sets itself
+var journalOwn = map[string]bool{
+	"MESSAGE":           true,
+	"PRIORITY":          true,
+	"MLOG_SEVERITY":     true,
+	"CODE_FILE":         true,
+	"CODE_LINE":         true,
+	"SYSLOG_IDENTIFIER": true,
+	"SYSLOG_PID":        true,
+	"CORRELATION_ID":    true,
+}
+
+// A JournalSink is a Sink sending records to systemd-journald with its
+// native protocol over the unix datagram socket at Path, "" for the
+// standard socket. Each record carries MESSAGE, PRIORITY (as for
+// syslog), MLOG_SEVERITY, CODE_FILE, CODE_LINE, SYSLOG_IDENTIFIER,
+// SYSLOG_PID, CORRELATION_ID and its fields with keys upper cased and
+// other characters than A-Z, 0-9 and _ replaced by _; a key starting
+// with _ or a digit is prefixed with F, one repeating any of those names
+// with F_. A record too large for a datagram is passed in a sealed memfd
+// (Linux only). The zero value is ready for use.
+type JournalSink struct {
+	Path string
+
+	mu   sync.Mutex
+	conn *net.UnixConn // unconnected, so it can pass descriptors
+	addr *net.UnixAddr
+}
+
+// Write sends the record; text is not used.
+func (s *JournalSink) Write(r *Record, text []byte) error {
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", r.Msg)
+	journalField(&b, "PRIORITY", strconv.Itoa(syslogsev[min(r.Sev, UNKNOWN)]))
+	journalField(&b, "MLOG_SEVERITY", sevstr[min(r.Sev, UNKNOWN)])
+	journalField(&b, "CODE_FILE", r.File)
+	journalField(&b, "CODE_LINE", strconv.Itoa(r.Line))
+	journalField(&b, "SYSLOG_IDENTIFIER", r.Name)
+	journalField(&b, "SYSLOG_PID", r.Pid)
+	journalField(&b, "CORRELATION_ID", r.CorelationId)
+	for _, f := range stackFields(r) {
+		journalField(&b, journalKey(f.Key), fmt.Sprint(f.Value))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+		if err != nil {
+			return err
+		}
+		path := s.Path
+		if path == "" {
+			path = cjournalpath
+		}
+		s.conn, s.addr = c, &net.UnixAddr{Name: path, Net: "unixgram"}
+	}
+	_, err := s.conn.WriteToUnix(b.Bytes(), s.addr)
+	if journalTooLarge(err) {
+		err = journalLarge(s.conn, s.addr, b.Bytes())
+	}
+	return err
+}
+
+// Close closes the socket.
+func (s *JournalSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// journalField writes KEY=value, or the binary form for values with
+// line breaks: KEY, a newline, the little endian 64 bit length, value
+func journalField(b *bytes.Buffer, key, value string) {
+	b.WriteString(key)
+	if strings.IndexByte(value, '\n') < 0 {
+		b.WriteByte('=')
+		b.WriteString(value)
+	} else {
+		b.WriteByte('\n')
+		binary.Write(b, binary.LittleEndian, uint64(len(value)))
+		b.WriteString(value)
+	}
+	b.WriteByte('\n')
+}
+
+// journalKey makes k a valid journal field name not set by the sink;
+// names starting with _ are reserved for journald, and a digit may not
+// start one
+func journalKey(k string) string {
+	k = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, k)
+	if k == "" || k[0] == '_' || (k[0] >= '0' && k[0] <= '9') {
+		k = "F" + k
+	} else if journalOwn[k] {
+		k = "F_" + k
+	}
+	if len(k) > 64 {
+		k = k[:64]
+	}
+	return k
+}
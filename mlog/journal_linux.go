@@ -0,0 +1,85 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// memfd_create system call numbers; syscall does not define them all
+var sysmemfd = map[string]uintptr{
+	"386":      356,
+	"amd64":    319,
+	"arm":      385,
+	"arm64":    279,
+	"loong64":  279,
+	"mips":     4354,
+	"mipsle":   4354,
+	"mips64":   5314,
+	"mips64le": 5314,
+	"ppc64":    360,
+	"ppc64le":  360,
+	"riscv64":  279,
+	"s390x":    350,
+}
+
+// memfd and sealing constants
+const (
+	cmfdcloexec      = 0x1
+	cmfdallowsealing = 0x2
+	cfaddseals       = 1033
+	csealall         = 0x1 | 0x2 | 0x4 | 0x8 // seal, shrink, grow, write
+)
+
+// journalTooLarge reports whether err is a datagram being too large
+func journalTooLarge(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS)
+}
+
+// journalLarge passes data to journald in a sealed memfd, or where that
+// is not available an unlinked file in /dev/shm
+func journalLarge(c *net.UnixConn, addr *net.UnixAddr, data []byte) error {
+	f, err := memfd(data)
+	if err != nil {
+		if f, err = os.CreateTemp("/dev/shm", "mlog-journal-"); err != nil {
+			return err
+		}
+		os.Remove(f.Name())
+		if _, err = f.Write(data); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	defer f.Close()
+	_, _, err = c.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), addr)
+	return err
+}
+
+// memfd returns a sealed memfd holding data
+func memfd(data []byte) (*os.File, error) {
+	nr, ok := sysmemfd[runtime.GOARCH]
+	if !ok {
+		return nil, syscall.ENOSYS
+	}
+	name := []byte("mlog-journal\x00")
+	fd, _, errno := syscall.Syscall(nr, uintptr(unsafe.Pointer(&name[0])), cmfdcloexec|cmfdallowsealing, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	f := os.NewFile(fd, "memfd:mlog-journal")
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, fd, cfaddseals, csealall); errno != 0 {
+		f.Close()
+		return nil, errno
+	}
+	return f, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// journalListen stands in for journald's socket
+func journalListen(t *testing.T) (*net.UnixConn, string) {
+	path := filepath.Join(t.TempDir(), "socket")
+	c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c, path
+}
+
+// journalParse decodes a native protocol payload
+func journalParse(t *testing.T, b []byte) map[string]string {
+	fields := map[string]string{}
+	for len(b) > 0 {
+		nl := bytes.IndexByte(b, '\n')
+		if nl < 0 {
+			t.Fatalf("unterminated field %q", b)
+		}
+		line := string(b[:nl])
+		b = b[nl+1:]
+		if k, v, ok := strings.Cut(line, "="); ok {
+			fields[k] = v
+			continue
+		}
+		n := binary.LittleEndian.Uint64(b)
+		fields[line] = string(b[8 : 8+n])
+		b = b[8+n+1:]
+	}
+	return fields
+}
+
+func TestJournal(t *testing.T) {
+	ln, path := journalListen(t)
+	l := New(nil, nil)
+	l.SetCorelationId("c1")
+	l.SetRoute(EVENT, &JournalSink{Path: path})
+	defer l.Close()
+
+	l.EventKV("two\nlines", "user-id", 7, "_hidden", 1, "message", "m", "code_line", 0)
+	buf := make([]byte, 4096)
+	n, err := ln.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := journalParse(t, buf[:n])
+	want := map[string]string{
+		"MESSAGE": "two\nlines", "PRIORITY": "5", "MLOG_SEVERITY": "EVENT",
+		"CODE_FILE": "journal_linux_test.go", "SYSLOG_PID": pid, "CORRELATION_ID": "c1",
+		"USER_ID": "7", "F_HIDDEN": "1", "F_MESSAGE": "m", "F_CODE_LINE": "0",
+	}
+	for k, v := range want {
+		if f[k] != v {
+			t.Errorf("%s = %q, want %q", k, f[k], v)
+		}
+	}
+}
+
+func TestJournalLarge(t *testing.T) {
+	ln, path := journalListen(t)
+	s := &JournalSink{Path: path}
+	defer s.Close()
+
+	big := strings.Repeat("x", 1<<20)
+	go func() {
+		if err := s.Write(&Record{Sev: ERROR, Msg: big}, nil); err != nil {
+			t.Error(err)
+			ln.Close()
+		}
+	}()
+
+	oob := make([]byte, syscall.CmsgSpace(4))
+	_, oobn, _, _, err := ln.ReadMsgUnix(nil, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("control messages %v, %v", msgs, err)
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("rights %v, %v", fds, err)
+	}
+	fd := os.NewFile(uintptr(fds[0]), "journal")
+	defer fd.Close()
+	fd.Seek(0, io.SeekStart)
+	b, err := io.ReadAll(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := journalParse(t, b); f["MESSAGE"] != big || f["PRIORITY"] != "3" {
+		t.Errorf("large record has %d byte message, priority %q", len(f["MESSAGE"]), f["PRIORITY"])
+	}
+}
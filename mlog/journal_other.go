@@ -0,0 +1,21 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+//go:build !linux
+
+package mlog
+
+import (
+	"errors"
+	"net"
+)
+
+// journalTooLarge reports false; large records cannot be passed anyway
+func journalTooLarge(err error) bool {
+	return false
+}
+
+// journalLarge fails; file descriptor passing to journald needs Linux
+func journalLarge(c *net.UnixConn, addr *net.UnixAddr, data []byte) error {
+	return errors.New("mlog: journald record too large for a datagram")
+}
@@ -0,0 +1,52 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// ParseSeverity converts a severity name, ALARM through DEBUG, to its
+// enumeration value. Case is ignored.
+func ParseSeverity(s string) (uint8, error) {
+	for i, n := range sevstr[:UNKNOWN] {
+		if strings.EqualFold(s, n) {
+			return uint8(i), nil
+		}
+	}
+	return UNKNOWN, fmt.Errorf("mlog: unknown severity %q", s)
+}
+
+// Set the least severe severity that is emitted; e.g. EVENT emits ALARM,
+// ERROR, STAT and EVENT records and discards INFO and DEBUG. UNKNOWN
+// records, written only by Emit, are never discarded. It may be changed
+// while the logger is in use.
+func (l *Logger) SetLevel(sev uint8) {
+	if sev > DEBUG {
+		sev = DEBUG
+	}
+	atomic.StoreUint32(&l.level, uint32(sev))
+}
+
+// Level returns the least severe severity emitted.
+func (l *Logger) Level() uint8 {
+	return uint8(atomic.LoadUint32(&l.level))
+}
+
+// Enabled reports whether records of the given severity are emitted.
+func (l *Logger) Enabled(sev uint8) bool {
+	return sev == UNKNOWN || uint32(sev) <= atomic.LoadUint32(&l.level)
+}
+
+// Set the level of the default logger, including the log package bridge.
+func SetLevel(sev uint8) {
+	std.SetLevel(sev)
+}
+
+// Level returns the level of the default logger.
+func Level() uint8 {
+	return std.Level()
+}
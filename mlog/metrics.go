@@ -0,0 +1,415 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metric types
+const (
+	MetricCounter   = "counter"
+	MetricGauge     = "gauge"
+	MetricHistogram = "histogram"
+)
+
+// A Metrics aggregates counters, gauges and histograms in process and
+// every interval emits a STAT record for each metric updated since the
+// last. The record's message identifies the metric in the form
+// name{label="value",...} with labels sorted by key; its fields are the
+// type and the values:
+//
+//	counter    value (total), delta (since the last record)
+//	gauge      value
+//	histogram  count, sum, min, max and mean of the observations since
+//	           the last record, and le_BOUND, the number at most BOUND
+//
+// Metrics are identified by name and labels; asking for one again
+// returns the existing metric.
+type Metrics struct {
+	l        *Logger
+	interval time.Duration
+
+	mu      sync.Mutex
+	metrics map[string]metric
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// metric is implemented by Counter, Gauge and Histogram
+type metric interface {
+	snapshot() Metric
+	report() ([]interface{}, bool) // fields since the last report, if updated
+}
+
+// NewMetrics returns a Metrics emitting through l every interval once
+// the first metric is created; 0 emits only when Emit is called.
+func NewMetrics(l *Logger, interval time.Duration) *Metrics {
+	return &Metrics{l: l, interval: interval, metrics: map[string]metric{}}
+}
+
+// the default Metrics, emitting through the default Logger
+var stats = NewMetrics(std, time.Minute)
+
+// Counter returns the counter with the name and labels, given as
+// alternating keys and values.
+func (m *Metrics) Counter(name string, labels ...string) *Counter {
+	return m.get(name, labels, func(d desc) metric { return &Counter{desc: d} }).(*Counter)
+}
+
+// Gauge returns the gauge with the name and labels.
+func (m *Metrics) Gauge(name string, labels ...string) *Gauge {
+	return m.get(name, labels, func(d desc) metric { return &Gauge{desc: d} }).(*Gauge)
+}
+
+// Histogram returns the histogram with the name and labels, counting
+// observations at most each of the ascending bounds; nil bounds only
+// summarize. The bounds of an existing histogram are not changed.
+func (m *Metrics) Histogram(name string, bounds []float64, labels ...string) *Histogram {
+	return m.get(name, labels, func(d desc) metric {
+		h := &Histogram{desc: d, bounds: append([]float64(nil), bounds...)}
+		h.total.reset(len(bounds))
+		h.last.reset(len(bounds))
+		return h
+	}).(*Histogram)
+}
+
+// Snapshot returns the current value of every metric, ordered by id.
+// Counter values and histogram summaries are totals.
+func (m *Metrics) Snapshot() []Metric {
+	var ms []Metric
+	for _, x := range m.sorted() {
+		ms = append(ms, x.snapshot())
+	}
+	return ms
+}
+
+// Emit writes a STAT record for each metric updated since the last.
+func (m *Metrics) Emit() {
+	if !m.l.Enabled(STAT) {
+		return
+	}
+	for _, x := range m.sorted() {
+		if kv, ok := x.report(); ok {
+			s := x.snapshot()
+			m.l.send(m.l.record(STAT, "mlog", 0, s.Id(), fields(kv)))
+		}
+	}
+}
+
+// Close stops the periodic records after emitting a last one.
+func (m *Metrics) Close() {
+	m.mu.Lock()
+	started := m.started
+	m.started = false
+	m.mu.Unlock()
+	if started {
+		close(m.stop)
+		<-m.done
+	}
+	m.Emit()
+}
+
+// get returns the metric with the name and labels, creating it with
+// create if it does not exist
+func (m *Metrics) get(name string, labels []string, create func(desc) metric) metric {
+	d := newDesc(name, labels)
+	id := d.id()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if x, ok := m.metrics[id]; ok {
+		return x
+	}
+	x := create(d)
+	m.metrics[id] = x
+	if !m.started && m.interval > 0 {
+		m.started = true
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.run(m.interval, m.stop, m.done)
+	}
+	return x
+}
+
+func (m *Metrics) run(interval time.Duration, stop, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			m.Emit()
+		}
+	}
+}
+
+func (m *Metrics) sorted() []metric {
+	m.mu.Lock()
+	ids := make([]string, 0, len(m.metrics))
+	for id := range m.metrics {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	xs := make([]metric, len(ids))
+	for i, id := range ids {
+		xs[i] = m.metrics[id]
+	}
+	m.mu.Unlock()
+	return xs
+}
+
+// NewCounter returns the counter of the default Metrics with the name
+// and labels, creating it if needed.
+func NewCounter(name string, labels ...string) *Counter {
+	return stats.Counter(name, labels...)
+}
+
+// NewGauge returns the gauge of the default Metrics.
+func NewGauge(name string, labels ...string) *Gauge {
+	return stats.Gauge(name, labels...)
+}
+
+// NewHistogram returns the histogram of the default Metrics.
+func NewHistogram(name string, bounds []float64, labels ...string) *Histogram {
+	return stats.Histogram(name, bounds, labels...)
+}
+
+// DefaultMetrics returns the Metrics emitting through the default Logger.
+func DefaultMetrics() *Metrics {
+	return stats
+}
+
+// A Metric is the value of a metric at the time of a Snapshot.
+type Metric struct {
+	Name    string
+	Labels  []Field // sorted by key, values are strings
+	Type    string
+	Value   float64 // counter total or gauge value
+	Count   uint64  // histogram observations
+	Sum     float64
+	Min     float64
+	Max     float64
+	Bounds  []float64
+	Buckets []uint64 // observations at most each bound
+}
+
+// Id returns the metric's identity, name{label="value",...}.
+func (m Metric) Id() string {
+	return desc{name: m.Name, labels: m.Labels}.id()
+}
+
+// desc is the name and sorted labels of a metric
+type desc struct {
+	name   string
+	labels []Field
+}
+
+func newDesc(name string, labels []string) desc {
+	d := desc{name: name}
+	for i := 0; i < len(labels); i += 2 {
+		v := cmissing
+		if i+1 < len(labels) {
+			v = labels[i+1]
+		}
+		d.labels = append(d.labels, Field{Key: labels[i], Value: v})
+	}
+	sort.SliceStable(d.labels, func(i, j int) bool { return d.labels[i].Key < d.labels[j].Key })
+	return d
+}
+
+func (d desc) id() string {
+	if len(d.labels) == 0 {
+		return d.name
+	}
+	var b strings.Builder
+	b.WriteString(d.name + "{")
+	for i, l := range d.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Key + "=" + strconv.Quote(l.Value.(string)))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func (d desc) metric(typ string) Metric {
+	return Metric{Name: d.name, Labels: d.labels, Type: typ}
+}
+
+// A Counter is a total that only increases.
+type Counter struct {
+	desc
+	n    uint64 // atomic
+	last uint64 // total at the last report
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.n, 1)
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n uint64) {
+	atomic.AddUint64(&c.n, n)
+}
+
+// Value returns the total.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.n)
+}
+
+func (c *Counter) snapshot() Metric {
+	m := c.desc.metric(MetricCounter)
+	m.Value = float64(c.Value())
+	return m
+}
+
+func (c *Counter) report() ([]interface{}, bool) {
+	n := c.Value()
+	last := atomic.SwapUint64(&c.last, n)
+	if n == last {
+		return nil, false
+	}
+	return []interface{}{"type", MetricCounter, "value", n, "delta", n - last}, true
+}
+
+// A Gauge is a value that goes up and down.
+type Gauge struct {
+	desc
+	bits    uint64 // atomic float64
+	updated uint32 // atomic; set since the last report
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	atomic.StoreUint64(&g.bits, math.Float64bits(v))
+	atomic.StoreUint32(&g.updated, 1)
+}
+
+// Add adds d, which may be negative, to the gauge.
+func (g *Gauge) Add(d float64) {
+	for {
+		old := atomic.LoadUint64(&g.bits)
+		if atomic.CompareAndSwapUint64(&g.bits, old, math.Float64bits(math.Float64frombits(old)+d)) {
+			break
+		}
+	}
+	atomic.StoreUint32(&g.updated, 1)
+}
+
+// Value returns the gauge's value.
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&g.bits))
+}
+
+func (g *Gauge) snapshot() Metric {
+	m := g.desc.metric(MetricGauge)
+	m.Value = g.Value()
+	return m
+}
+
+func (g *Gauge) report() ([]interface{}, bool) {
+	if atomic.SwapUint32(&g.updated, 0) == 0 {
+		return nil, false
+	}
+	return []interface{}{"type", MetricGauge, "value", g.Value()}, true
+}
+
+// A Histogram summarizes observations such as sizes or latencies.
+type Histogram struct {
+	desc
+	bounds []float64
+	mu     sync.Mutex
+	total  hstats // since creation
+	last   hstats // since the last report
+}
+
+// summary of observations
+type hstats struct {
+	count    uint64
+	sum      float64
+	min, max float64
+	buckets  []uint64
+}
+
+func (s *hstats) reset(n int) {
+	*s = hstats{min: math.Inf(1), max: math.Inf(-1), buckets: make([]uint64, n)}
+}
+
+func (s *hstats) observe(v float64, bounds []float64) {
+	s.count++
+	s.sum += v
+	s.min = math.Min(s.min, v)
+	s.max = math.Max(s.max, v)
+	for i, b := range bounds {
+		if v <= b {
+			s.buckets[i]++
+		}
+	}
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.total.observe(v, h.bounds)
+	h.last.observe(v, h.bounds)
+}
+
+// ObserveDuration records d in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Time returns a func recording the time until it is called:
+//
+//	defer h.Time()()
+func (h *Histogram) Time() func() {
+	start := time.Now()
+	return func() { h.ObserveDuration(time.Since(start)) }
+}
+
+func (h *Histogram) snapshot() Metric {
+	m := h.desc.metric(MetricHistogram)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m.Count, m.Sum, m.Bounds = h.total.count, h.total.sum, h.bounds
+	if m.Count > 0 {
+		m.Min, m.Max = h.total.min, h.total.max
+	}
+	m.Buckets = append([]uint64(nil), h.total.buckets...)
+	return m
+}
+
+func (h *Histogram) report() ([]interface{}, bool) {
+	h.mu.Lock()
+	s := h.last
+	h.last.reset(len(h.bounds))
+	h.mu.Unlock()
+	if s.count == 0 {
+		return nil, false
+	}
+	kv := []interface{}{
+		"type", MetricHistogram,
+		"count", s.count,
+		"sum", s.sum,
+		"min", s.min,
+		"max", s.max,
+		"mean", s.sum / float64(s.count),
+	}
+	for i, b := range h.bounds {
+		kv = append(kv, "le_"+strconv.FormatFloat(b, 'g', -1, 64), s.buckets[i])
+	}
+	return kv, true
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	l.SetSuppress(true)
+	m := NewMetrics(l, 0)
+
+	reqs := m.Counter("requests", "method", "GET", "code", "200")
+	if m.Counter("requests", "code", "200", "method", "GET") != reqs {
+		t.Error("label order made a new counter")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				reqs.Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	m.Gauge("queue").Set(3)
+	m.Gauge("queue").Add(-1.5)
+	lat := m.Histogram("latency", []float64{0.1, 1})
+	for _, v := range []float64{0.05, 0.5, 2, 0.5} {
+		lat.Observe(v)
+	}
+	m.Counter("idle")
+
+	m.Emit()
+	want := `*2|15|STAT||||||latency|type=histogram|count=4|sum=3.05|min=0.05|max=2|mean=0.7625|le_0.1=1|le_1=3` + "\n" +
+		`*2|9|STAT||||||queue|type=gauge|value=1.5` + "\n" +
+		`*2|10|STAT||||||requests{code="200",method="GET"}|type=counter|value=1000|delta=1000` + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("output =\n%s\nwant\n%s", got, want)
+	}
+
+	// only updated metrics are reported again
+	out.Reset()
+	reqs.Add(5)
+	m.Emit()
+	if got, want := out.String(), `*2|10|STAT||||||requests{code="200",method="GET"}|type=counter|value=1005|delta=5`+"\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	snap := m.Snapshot()
+	if len(snap) != 4 || snap[1].Id() != "latency" || snap[1].Count != 4 || snap[1].Buckets[1] != 3 ||
+		snap[3].Value != 1005 || snap[3].Labels[0].Key != "code" {
+		t.Errorf("snapshot = %+v", snap)
+	}
+}
+
+func TestMetricsInterval(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	l.SetSuppress(true)
+	l.SetAsync(16, Block, 0)
+	m := NewMetrics(l, 10*time.Millisecond)
+	m.Counter("ticks").Inc()
+	time.Sleep(50 * time.Millisecond)
+	m.Close()
+	l.Flush()
+	if got, want := out.String(), "*2|10|STAT||||||ticks|type=counter|value=1|delta=1\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
@@ -18,20 +18,28 @@ limitations under the License.
 package mlog
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lavaorg/lrt/env"
 )
 
 const (
-	cversion     = "1"
+	cversion     = "2"
 	cmarker      = "*" + cversion
+	cversion1    = "1"
+	cmarker1     = "*" + cversion1
+	cfields2     = 7 // fields of a version 2 record before any key=value
 	cseparator   = "|"
 	ctmformat    = "2006/01/02 15:04:05.999999"
 	ctmformatlen = len(ctmformat)
@@ -39,9 +47,38 @@ const (
 
 // information from the environment
 type Ext struct {
-	CorelationId string `default:"0" desc: "correlates across multiple apps"`
-	Debug        bool   `default:false`
-	MlogSuppress bool   `default:false`
+	CorelationId  string `default:"0" desc:"correlates across multiple apps"`
+	Debug         bool   `default:"false"`
+	MlogSuppress  bool   `default:"false"`
+	MlogWire      int    `default:"2" desc:"mlog format version, 1 for compatibility"`
+	MlogMultiline string `desc:"multi-line messages: split, single or continue"`
+	MlogFormat    string `default:"mlog" desc:"output format: mlog, json or logfmt"`
+	MlogLevel     string `default:"INFO" desc:"least severe severity emitted, ALARM..DEBUG"`
+	MlogVmodule   string `desc:"per file or package levels, e.g. db*=DEBUG,http/server.go=INFO"`
+	MlogStack     string `desc:"stack frames captured per severity, e.g. ALARM=32,ERROR=16"`
+	MlogPanic     string `default:"repanic" desc:"after Recover logs a panic: repanic, exit or continue"`
+	MlogPanicExit int    `default:"2" desc:"exit status when MlogPanic is exit"`
+
+	// output to a rotating file instead of stdout/stderr
+	MlogFile         string        `desc:"path of the log file"`
+	MlogFileMaxSize  int64         `desc:"rotate when the file would exceed this many bytes"`
+	MlogFileMaxAge   time.Duration `desc:"rotate when the file has been open this long"`
+	MlogFileBackups  int           `desc:"number of rotated files kept, 0 keeps all"`
+	MlogFileCompress bool          `default:"false" desc:"gzip rotated files"`
+
+	// routing of severities to sinks
+	MlogRoute       string `desc:"e.g. *=stdout or *=stderr,STAT=file:/var/log/stat.log"`
+	MlogSpool       string `desc:"directory spooling records for unreachable tcp and tls sinks"`
+	MlogSpoolMax    int64  `desc:"bytes spooled per network sink, 0 for 64MiB"`
+	MlogOtlpHeaders string `desc:"headers of OTLP requests, e.g. Authorization=Bearer x"`
+
+	// asynchronous output
+	MlogAsync       int           `desc:"queue size for asynchronous output, 0 writes synchronously"`
+	MlogAsyncPolicy string        `default:"block" desc:"when the queue is full: block, dropnewest or droplowest"`
+	MlogAsyncStats  time.Duration `default:"1m" desc:"interval of the dropped record STAT"`
+
+	// metrics
+	MlogStatInterval time.Duration `default:"1m" desc:"interval of metric STAT records, 0 emits none"`
 }
 
 // Severity Enumeration
@@ -59,11 +96,17 @@ var (
 	ext  Ext    // external descriptive info
 	name string // process name
 	pid  string // os pid
-	mlw  mlogwriter
 
-	// these must be initialized early
-	stderr = os.Stderr
-	stdout = os.Stdout
+	// the default logger; these must be initialized early
+	std = &Logger{core: &core{
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		routes: streams(os.Stdout, os.Stderr),
+		format: FormatMlog,
+		enc:    encodeMlog,
+		opts:   encopts{wire: 2},
+		level:  uint32(INFO),
+	}}
 
 	// map sev enum to strings
 	sevstr []string = []string{
@@ -86,105 +129,468 @@ func initialize() {
 	pathx := strings.Split(os.Args[0], "/")
 	name = pathx[len(pathx)-1]
 	pid = strconv.Itoa(os.Getpid())
+	std.name, std.pid = name, pid
 
 	err := env.Load("lrt", &ext)
 	if err != nil {
 		Emit(0, ERROR, "could not get env vars:"+err.Error())
 		os.Exit(1)
 	}
+	std.corelationId = ext.CorelationId
+	std.opts.suppress = ext.MlogSuppress
+	if err := std.SetWireVersion(ext.MlogWire); err != nil {
+		Emit(0, ERROR, err.Error())
+	}
+	if err := std.SetMultiline(ext.MlogMultiline); err != nil {
+		Emit(0, ERROR, err.Error())
+	}
+	if err := std.SetFormat(ext.MlogFormat); err != nil {
+		Emit(0, ERROR, err.Error())
+	}
+	if lev, err := ParseSeverity(ext.MlogLevel); err != nil {
+		Emit(0, ERROR, err.Error())
+	} else {
+		std.SetLevel(lev)
+	}
+	if ext.Debug {
+		std.SetLevel(DEBUG)
+	}
+	if err := std.SetVmodule(ext.MlogVmodule); err != nil {
+		Emit(0, ERROR, err.Error())
+	}
+	if depths, err := parseStack(ext.MlogStack); err != nil {
+		Emit(0, ERROR, err.Error())
+	} else {
+		for sev, depth := range depths {
+			std.SetStack(uint8(sev), depth)
+		}
+	}
+	if action, err := ParsePanicAction(ext.MlogPanic); err != nil {
+		Emit(0, ERROR, err.Error())
+	} else {
+		std.SetPanicAction(action, ext.MlogPanicExit)
+	}
+	if ext.MlogFile != "" {
+		f := &RotatingFile{
+			Path:       ext.MlogFile,
+			MaxSize:    ext.MlogFileMaxSize,
+			MaxAge:     ext.MlogFileMaxAge,
+			MaxBackups: ext.MlogFileBackups,
+			Compress:   ext.MlogFileCompress,
+		}
+		if err := f.Reopen(); err != nil {
+			Emit(0, ERROR, "could not open log file:"+err.Error())
+		} else {
+			std.SetOutput(f, f)
+		}
+	}
+	if err := std.SetRoutes(ext.MlogRoute); err != nil {
+		Emit(0, ERROR, err.Error())
+	}
+	stats.interval = ext.MlogStatInterval
+	if ext.MlogAsync > 0 {
+		if policy, err := ParseAsyncPolicy(ext.MlogAsyncPolicy); err != nil {
+			Emit(0, ERROR, err.Error())
+		} else {
+			std.SetAsync(ext.MlogAsync, policy, ext.MlogAsyncStats)
+		}
+	}
+
+	// send log/slog records to this logger, then force all golog
+	// logging to it (slog.SetDefault redirects the log package)
+	slog.SetDefault(slog.New(NewHandler(std)))
+	log.SetOutput(std.Writer())
+	log.SetFlags(log.Llongfile) // mlog adds date/time + other information
+}
+
+// A Logger emits mlog records to its own pair of output streams with its
+// own correlation id, severity level and format. The package level functions
+// emit through a default Logger configured from the environment.
+type Logger struct {
+	*core
+	fields []Field // added to every record
+	corr   string  // correlation id overriding the core's, if set
+}
 
-	// force all golog logging to this logger
-	log.SetOutput(mlw)
-	log.SetFlags(0) // mlog will get date/time + other information
+// settings shared by a Logger and the children created by With;
+// mu guards the settings, wmu serializes writes so that each record
+// reaches its stream whole and in order
+type core struct {
+	mu           sync.Mutex
+	wmu          sync.Mutex
+	corelationId string
+	name         string    // process name
+	pid          string    // os pid
+	stdout       io.Writer // INFO and DEBUG by default
+	stderr       io.Writer // ALARM, ERROR, STAT and EVENT by default
+	routes       [UNKNOWN + 1][]Sink
+	format       string
+	enc          encoder
+	opts         encopts
+	level        uint32 // least severe severity emitted; atomic
+	vmodule      atomic.Pointer[vmodule]
+	stack        [UNKNOWN + 1]int32 // frames captured per severity; atomic
+	panicAction  PanicAction
+	panicCode    int
+	async        atomic.Pointer[async] // nil when writing synchronously
 }
 
-// GologWriter
-type mlogwriter int
+// New creates a Logger writing to the given streams. The process
+// information and environment settings are copied from the default Logger.
+func New(stdout, stderr io.Writer) *Logger {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	c := &core{
+		corelationId: std.corelationId,
+		opts:         std.opts,
+		name:         std.name,
+		pid:          std.pid,
+		stdout:       stdout,
+		stderr:       stderr,
+		routes:       streams(stdout, stderr),
+		format:       std.format,
+		enc:          std.enc,
+		level:        uint32(std.Level()),
+		panicAction:  std.panicAction,
+		panicCode:    std.panicCode,
+	}
+	c.vmodule.Store(std.vmodule.Load())
+	for i := range c.stack {
+		c.stack[i] = atomic.LoadInt32(&std.stack[i])
+	}
+	return &Logger{core: c}
+}
+
+// Default returns the Logger used by the package level functions.
+func Default() *Logger {
+	return std
+}
+
+// Set the streams records are written to, restoring the default
+// routing: ALARM, ERROR, STAT and EVENT to stderr, the rest to stdout.
+func (l *Logger) SetOutput(stdout, stderr io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stdout, l.stderr = stdout, stderr
+	l.routes = streams(stdout, stderr)
+}
+
+// Set the correlation id stamped into each record.
+func (l *Logger) SetCorelationId(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.corelationId = id
+}
+
+// Enable Debug Messaging; disabling lowers the level to INFO
+func (l *Logger) EnableDebug(flag bool) {
+	if flag {
+		l.SetLevel(DEBUG)
+	} else if l.Level() == DEBUG {
+		l.SetLevel(INFO)
+	}
+}
+
+// Suppress the header fields, emitting only the marker and severity.
+func (l *Logger) SetSuppress(flag bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.opts.suppress = flag
+}
+
+// Select the version of the mlog format written, 1 or 2.
+func (l *Logger) SetWireVersion(v int) error {
+	if v != 1 && v != 2 {
+		return fmt.Errorf("mlog: unknown format version %d", v)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if v == 1 && l.opts.multiline == MultilineSingle {
+		return fmt.Errorf("mlog: format version 1 cannot write %s multi-line records", MultilineSingle)
+	}
+	l.opts.wire = v
+	return nil
+}
+
+// Select how the mlog format writes multi-line messages: MultilineSplit,
+// MultilineSingle or MultilineContinue. Empty selects the default of
+// the format version.
+func (l *Logger) SetMultiline(mode string) error {
+	switch mode {
+	case "", MultilineSplit, MultilineSingle, MultilineContinue:
+	default:
+		return fmt.Errorf("mlog: unknown multi-line mode %q", mode)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.opts.wire == 1 && mode == MultilineSingle {
+		return fmt.Errorf("mlog: format version 1 cannot write %s multi-line records", MultilineSingle)
+	}
+	l.opts.multiline = mode
+	return nil
+}
+
+// Select the output format, FormatMlog, FormatJSON or FormatLogfmt.
+func (l *Logger) SetFormat(format string) error {
+	enc, ok := encoders[format]
+	if !ok {
+		return fmt.Errorf("mlog: unknown format %q", format)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.format, l.enc = format, enc
+	return nil
+}
+
+// Format returns the name of the output format.
+func (l *Logger) Format() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.format
+}
+
+// GologWriter; bridges the standard Go log package to a Logger
+type mlogwriter struct {
+	l *Logger
+}
+
+// frames examined looking for the caller of a log package function
+const clogdepth = 32
+
+// Writer returns a writer for log.New that emits each line written as a
+// record of l. The log package's Fatal and Panic functions emit ALARMs,
+// the rest INFO. Use the flag log.Llongfile (or log.Lshortfile) and no
+// other flags to have the calldepth of Output honored; the default
+// Logger's log package output is set up that way.
+func (l *Logger) Writer() io.Writer {
+	return mlogwriter{l}
+}
 
 // A Writer to replace the version used by the standard Go log package
-func (mlogwriter) Write(buffer []byte) (n int, err error) {
-
-	// For example, the call stack for log.Printf
-	// call as obtianed from debugTrace is
-	//
-	// function calling log method -> go/src/log/log:289
-	//                             -> go/src/log/log.go:166
-	//                             -> <autogenerated>:1
-	//                             -> this method
-	//
-	// log.Printf call corresponds to "go/src/log/log:289"
+func (w mlogwriter) Write(buffer []byte) (n int, err error) {
+	sev, frames := logCaller()
+	msg := strings.TrimSuffix(string(buffer), "\n")
 
-	sev := INFO
-	// evaluate source
-	pc, _, _, ok := runtime.Caller(3)
-	if ok {
-		fnName := runtime.FuncForPC(pc).Name()
-		if strings.HasPrefix(fnName, "log.Fatal") || strings.HasPrefix(fnName, "log.Panic") {
-			sev = ALARM
+	var caller runtime.Frame
+	if len(frames) > 0 {
+		caller = frames[0]
+	}
+	// the source written by the log package reflects the calldepth
+	// given to Output; find its frame and drop it from the message,
+	// which may start with the log.Logger's prefix
+	if f, i, n, ok := logSource(msg, frames); ok {
+		caller = f
+		msg = msg[:i] + msg[i+n:]
+	}
+	if caller.File == "" {
+		caller.File = "???"
+	}
+
+	l := w.l
+	if l.allowed(sev, caller.PC, caller.File) {
+		var st []Frame
+		if depth := int(atomic.LoadInt32(&l.stack[sev])); depth > 0 {
+			for _, f := range frames {
+				if len(st) == depth {
+					break
+				}
+				st = append(st, Frame{Function: f.Function, File: f.File, Line: f.Line})
+			}
 		}
+		l.emit(sev, caller.File, caller.Line, msg, l.fields, st)
 	}
-	// get correct file and line
-	_, file, line, ok := runtime.Caller(4)
-	if !ok {
-		file = "???"
-		line = 0
+	if sev == ALARM {
+		// log.Fatal exits next
+		l.sync()
 	}
-	emit(sev, file, line, string(buffer))
 
 	// return ok
 	return len(buffer), nil
 }
 
+// logCaller walks the stack above Write past the log package functions
+// and autogenerated wrappers. It returns ALARM if one of those was a
+// Fatal or Panic function, else INFO, and the frames outward from the
+// function that called the log package.
+func logCaller() (uint8, []runtime.Frame) {
+	pcs := make([]uintptr, clogdepth)
+	n := runtime.Callers(3, pcs) // skip Callers, logCaller and Write
+	frames := runtime.CallersFrames(pcs[:n])
+
+	sev := INFO
+	var all, out []runtime.Frame
+	inlog := false
+	for {
+		f, more := frames.Next()
+		all = append(all, f)
+		switch {
+		case out != nil:
+			out = append(out, f)
+		case strings.HasPrefix(f.Function, "log.") || (inlog && f.File == "<autogenerated>"):
+			// functions of the log package itself
+			inlog = true
+			name := f.Function[strings.LastIndexByte(f.Function, '.')+1:]
+			if strings.HasPrefix(name, "Fatal") || strings.HasPrefix(name, "Panic") {
+				sev = ALARM
+			}
+		case inlog:
+			out = append(out, f)
+		}
+		if !more {
+			break
+		}
+	}
+	if !inlog {
+		// written to directly, not through the log package
+		return sev, all
+	}
+	return sev, out
+}
+
+// logSource finds the earliest "file.go:line: " of one of the frames in
+// m, with the file in full or its base name, returning the frame and
+// the position and length of the source
+func logSource(m string, frames []runtime.Frame) (f runtime.Frame, at, n int, ok bool) {
+	at = len(m)
+	for _, fr := range frames {
+		tail := ":" + strconv.Itoa(fr.Line) + ": "
+		short := fr.File[strings.LastIndexByte(fr.File, '/')+1:]
+		for _, src := range []string{fr.File + tail, short + tail} {
+			if i := strings.Index(m, src); i >= 0 && i < at {
+				f, at, n, ok = fr, i, len(src), true
+			}
+		}
+	}
+	return f, at, n, ok
+}
+
 // Enable Debug Messaging
 func EnableDebug(flag bool) {
-	ext.Debug = flag
+	std.EnableDebug(flag)
 }
 
-// Emit debug message if global debug flag set
+// Emit debug message if debug is enabled
 func Debug(template string, args ...interface{}) {
-	if ext.Debug {
-		Emit(1, DEBUG, fmt.Sprintf(template, args...))
+	if std.possible(DEBUG) {
+		std.output(1, DEBUG, fmt.Sprintf(template, args...))
 	}
 }
 
 // Emit an Event message
 func Event(template string, args ...interface{}) {
-	Emit(1, EVENT, fmt.Sprintf(template, args...))
+	std.output(1, EVENT, fmt.Sprintf(template, args...))
 }
 
 // Emit an Info message
 func Info(template string, args ...interface{}) {
-	Emit(1, INFO, fmt.Sprintf(template, args...))
+	std.output(1, INFO, fmt.Sprintf(template, args...))
 }
 
 // Emit a Stat message
 func Stat(template string, args ...interface{}) {
-	Emit(1, STAT, fmt.Sprintf(template, args...))
+	std.output(1, STAT, fmt.Sprintf(template, args...))
 }
 
 // Emit an Error message
 func Error(template string, args ...interface{}) {
-	Emit(1, ERROR, fmt.Sprintf(template, args...))
+	std.output(1, ERROR, fmt.Sprintf(template, args...))
 }
 
 // Emit using the alarm severity level
 func Alarm(template string, args ...interface{}) {
-	Emit(1, ALARM, fmt.Sprintf(template, args...))
+	std.output(1, ALARM, fmt.Sprintf(template, args...))
 }
 
 // Emit a custom type and message (emits to stdout)
 // lev is file:line in call stack to emit
 func Emit(lev int, severity uint8, m string) {
+	std.output(lev+1, severity, m)
+}
+
+// Emit debug message if the logger's debug is enabled
+func (l *Logger) Debug(template string, args ...interface{}) {
+	if l.possible(DEBUG) {
+		l.output(1, DEBUG, fmt.Sprintf(template, args...))
+	}
+}
+
+// Emit an Event message
+func (l *Logger) Event(template string, args ...interface{}) {
+	l.output(1, EVENT, fmt.Sprintf(template, args...))
+}
+
+// Emit an Info message
+func (l *Logger) Info(template string, args ...interface{}) {
+	l.output(1, INFO, fmt.Sprintf(template, args...))
+}
+
+// Emit a Stat message
+func (l *Logger) Stat(template string, args ...interface{}) {
+	l.output(1, STAT, fmt.Sprintf(template, args...))
+}
+
+// Emit an Error message
+func (l *Logger) Error(template string, args ...interface{}) {
+	l.output(1, ERROR, fmt.Sprintf(template, args...))
+}
+
+// Emit using the alarm severity level
+func (l *Logger) Alarm(template string, args ...interface{}) {
+	l.output(1, ALARM, fmt.Sprintf(template, args...))
+}
+
+// Emit a custom type and message
+// lev is file:line in call stack to emit
+func (l *Logger) Emit(lev int, severity uint8, m string) {
+	l.output(lev+1, severity, m)
+}
+
+// output determines the caller lev frames above its own caller
+func (l *Logger) output(lev int, sev uint8, m string) {
+	l.outputKV(lev+1, sev, m, nil)
+}
+
+func (l *Logger) outputKV(lev int, sev uint8, m string, kv []interface{}) {
+	if !l.possible(sev) {
+		return
+	}
 	// get caller statistics
-	_, file, line, ok := runtime.Caller(lev + 1)
+	pc, file, line, ok := runtime.Caller(lev + 1)
 	if !ok {
 		file = "???"
 		line = 0
 	}
-	emit(severity, file, line, m)
+	if !l.allowed(sev, pc, file) {
+		return
+	}
+	fs := l.fields
+	if len(kv) > 0 {
+		fs = append(append([]Field(nil), l.fields...), fields(kv)...)
+	}
+	var st []Frame
+	if depth := atomic.LoadInt32(&l.stack[sev]); depth > 0 {
+		st = callers(lev+1, int(depth))
+	}
+	l.emit(sev, file, line, m, fs, st)
+}
+
+func (l *Logger) emit(sev uint8, file string, line int, m string, fs []Field, st []Frame) {
+	r := l.record(sev, file, line, m, fs)
+	r.Stack = st
+	l.send(r)
+}
+
+// send queues the record when asynchronous, else writes it
+func (l *Logger) send(r *Record) {
+	if a := l.async.Load(); a != nil {
+		a.put(r)
+		return
+	}
+	l.write(r)
 }
 
-func emit(sev uint8, file string, line int, m string) {
+// record creates a record stamped with the logger's process information
+func (l *Logger) record(sev uint8, file string, line int, m string, fs []Field) *Record {
 
 	// determine the shorted-version of the filename
 	// and avoid the func call of strings.SplitAfter
@@ -195,36 +601,40 @@ func emit(sev uint8, file string, line int, m string) {
 			break
 		}
 	}
-	file = short
-
-	linx := strconv.Itoa(line)
-	fileAndLine := strings.Join([]string{file, linx}, ":")
 
-	// then split into individual lines (by CR)
-	lines := strings.Split(m, "\n")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	corr := l.corelationId
+	if l.corr != "" {
+		corr = l.corr
+	}
+	return &Record{
+		Sev:          sev,
+		CorelationId: corr,
+		Pid:          l.pid,
+		Name:         l.name,
+		File:         short,
+		Line:         line,
+		Time:         time.Now(),
+		Msg:          m,
+		Fields:       fs,
+	}
+}
 
-	// format each message from caller statistics
-	// and output to the correct stream
-	stream := stderr
-	if sev > EVENT {
-		stream = stdout
+// write formats the record and outputs it to the sinks routed for its
+// severity with a single Write each; all lines of a record stay together
+func (l *Logger) write(r *Record) {
+	var b bytes.Buffer
+	l.mu.Lock()
+	sinks := l.routes[min(r.Sev, UNKNOWN)]
+	if len(sinks) > 0 {
+		l.enc(&b, r, &l.opts)
 	}
+	l.mu.Unlock()
 
-	// create a structured log message to emit
-	var message string
-	if ext.MlogSuppress {
-		message = strings.Join([]string{cmarker, sevstr[sev]}, cseparator)
-	} else {
-		timestamp := time.Now().UTC().Format(ctmformat)
-		message = strings.Join([]string{
-			cmarker, sevstr[sev], ext.CorelationId, pid, name, fileAndLine, timestamp,
-		}, cseparator)
-	}
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-		// Write message to stdout or stderr
-		fmt.Fprintln(stream, message+cseparator+line)
+	l.wmu.Lock()
+	defer l.wmu.Unlock()
+	for _, s := range sinks {
+		s.Write(r, b.Bytes())
 	}
 }
@@ -17,7 +17,10 @@ limitations under the License.
 package mlog
 
 import (
+	"bytes"
+	"io"
 	"log"
+	"strings"
 	"testing"
 )
 
@@ -25,7 +28,114 @@ func TestOne(t *testing.T) {
 
 }
 
-func ExampleUse() {
+func TestLogger(t *testing.T) {
+	var out, errs bytes.Buffer
+	l := New(&out, &errs)
+	l.SetSuppress(true)
+
+	l.Info("hello %s", "info")
+	l.Debug("not shown")
+	l.EnableDebug(true)
+	l.Debug("shown")
+	l.Error("two\nlines")
+
+	if got, want := out.String(), "*2|7|INFO||||||hello info\n*2|7|DEBUG||||||shown\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if got, want := errs.String(), "*2|7|ERROR||||||two\\nlines\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+
+	errs.Reset()
+	l.SetSuppress(false)
+	l.SetCorelationId("abc")
+	l.Event("full")
+	want := "*2|7|EVENT|abc|" + pid + "|" + escape(name) + "|mlog_test.go:"
+	if got := errs.String(); !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "|full\n") {
+		t.Errorf("stderr = %q, want prefix %q", got, want)
+	}
+
+	errs.Reset()
+	if err := l.SetWireVersion(3); err == nil {
+		t.Error("SetWireVersion accepted 3")
+	}
+	l.SetWireVersion(1)
+	l.Event("full")
+	want = "*1|EVENT|abc|" + pid + "|" + name + "|mlog_test.go:"
+	if got := errs.String(); !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "|full\n") {
+		t.Errorf("stderr = %q, want prefix %q", got, want)
+	}
+
+	errs.Reset()
+	l.SetSuppress(true)
+	l.Error("two\nlines")
+	if got, want := errs.String(), "*1|ERROR|two\n*1|ERROR|lines\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+}
+
+func TestFields(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	l.SetSuppress(true)
+	l.SetWireVersion(1)
+
+	l.InfoKV("request", "user", 42, "path", "/a b", "ok")
+	l.With("svc", "db").InfoKV("query", "rows", 3)
+	l.With("k", "v|w").Info("")
+
+	want := "*1|INFO|request {user=42 path=\"/a b\" ok=!MISSING}\n" +
+		"*1|INFO|query {svc=db rows=3}\n" +
+		"*1|INFO|{k=\"v|w\"}\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+// redirect points the default logger, in short form, at w until the
+// returned func is called
+func redirect(w io.Writer) (restore func()) {
+	saved := std.core
+	std.core = New(w, w).core
+	std.SetSuppress(true)
+	return func() { std.core = saved }
+}
+
+func TestLevel(t *testing.T) {
+	var out bytes.Buffer
+	defer redirect(&out)()
+
+	if sev, err := ParseSeverity("event"); err != nil || sev != EVENT {
+		t.Fatalf("ParseSeverity = %d, %v", sev, err)
+	}
+	if _, err := ParseSeverity("LOUD"); err == nil {
+		t.Error("ParseSeverity accepted LOUD")
+	}
+
+	child := With("k", 1)
+	SetLevel(EVENT)
+	Info("no")
+	child.InfoKV("no")
+	log.Println("no")
+	Event("yes")
+	Emit(0, UNKNOWN, "yes")
+	child.Stat("yes")
+	EnableDebug(true)
+	Debug("yes")
+	log.Println("yes")
+	EnableDebug(false)
+	Debug("no")
+
+	want := "*2|7|EVENT||||||yes\n*2|7|UNKNOWN||||||yes\n*2|8|STAT||||||yes|k=1\n*2|7|DEBUG||||||yes\n*2|7|INFO||||||yes\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	if Level() != INFO {
+		t.Errorf("level = %s after EnableDebug(false)", sevstr[Level()])
+	}
+}
+
+func Example() {
 
 	Info("%s", "Hello Info")
 	Debug("%s", "Hello Debug")
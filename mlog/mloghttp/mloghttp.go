@@ -0,0 +1,173 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+// Package mloghttp propagates mlog correlation ids through HTTP servers
+// and clients and logs an EVENT record per request served.
+//
+//	http.ListenAndServe(":8080", mloghttp.Handler(mux))
+//	client := &http.Client{Transport: &mloghttp.Transport{}}
+//
+// Requests made with a context derived from the server request carry its
+// correlation id to the downstream service.
+package mloghttp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/lavaorg/lrt/mlog"
+)
+
+// DefaultHeader carries the correlation id when no header is configured.
+const DefaultHeader = "X-Correlation-Id"
+
+// A Middleware is an http.Handler that takes the correlation id of a
+// request from Header, or creates one, stores it in the request context
+// (see mlog.WithCorelationId), echoes it in the response and after Next
+// returns logs an EVENT with the method, path, status, bytes written
+// and latency. The response writer passed to Next is an http.Flusher,
+// http.Hijacker and io.ReaderFrom whatever the server's is; Hijack fails
+// with http.ErrNotSupported where the server's cannot, and a hijacked
+// connection is logged with status 101 unless a status was written.
+type Middleware struct {
+	Next   http.Handler
+	Logger *mlog.Logger  // nil logs to mlog.Default()
+	Header string        // "" uses DefaultHeader
+	NewId  func() string // creates missing ids; nil uses random hex
+}
+
+// Handler returns a Middleware around next with the default settings.
+func Handler(next http.Handler) http.Handler {
+	return &Middleware{Next: next}
+}
+
+func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	header := m.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+	id := r.Header.Get(header)
+	if id == "" {
+		if m.NewId != nil {
+			id = m.NewId()
+		} else {
+			id = newId()
+		}
+	}
+	ctx := mlog.WithCorelationId(r.Context(), id)
+	w.Header().Set(header, id)
+
+	sw := &statusWriter{ResponseWriter: w}
+	m.Next.ServeHTTP(sw, r.WithContext(ctx))
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+
+	l := m.Logger
+	if l == nil {
+		l = mlog.Default()
+	}
+	l.WithContext(ctx).EventKV("http request",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", sw.status,
+		"bytes", sw.bytes,
+		"latency", time.Since(start))
+}
+
+// A Transport is an http.RoundTripper adding the correlation id of the
+// request context to outgoing requests that do not already carry one.
+type Transport struct {
+	Base   http.RoundTripper // nil uses http.DefaultTransport
+	Header string            // "" uses DefaultHeader
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	header := t.Header
+	if header == "" {
+		header = DefaultHeader
+	}
+	if id, ok := mlog.CorelationIdFromContext(r.Context()); ok && r.Header.Get(header) == "" {
+		// a RoundTripper must not modify the caller's request
+		r = r.Clone(r.Context())
+		r.Header.Set(header, id)
+	}
+	return base.RoundTrip(r)
+}
+
+// statusWriter records the status and size of a response, passing on
+// the optional interfaces of the underlying writer
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// ReadFrom lets the underlying writer copy from r efficiently, e.g. with
+// sendfile.
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := io.Copy(w.ResponseWriter, r)
+	w.bytes += n
+	return n, err
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func newId() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
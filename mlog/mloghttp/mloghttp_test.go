@@ -0,0 +1,135 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mloghttp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/lavaorg/lrt/mlog"
+)
+
+func TestPropagation(t *testing.T) {
+	// downstream service reports the id it received
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Header.Get(DefaultHeader))
+	}))
+	defer down.Close()
+
+	var out bytes.Buffer
+	l := mlog.New(&out, &out)
+	client := &http.Client{Transport: &Transport{}}
+	h := &Middleware{Logger: l, Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, _ := http.NewRequestWithContext(r.Context(), "GET", down.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(http.StatusTeapot)
+		io.Copy(w, resp.Body)
+	})}
+
+	req := httptest.NewRequest("GET", "/brew", nil)
+	req.Header.Set(DefaultHeader, "abc")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Body.String() != "abc" || rec.Header().Get(DefaultHeader) != "abc" {
+		t.Errorf("downstream saw %q, response header %q", rec.Body.String(), rec.Header().Get(DefaultHeader))
+	}
+	got := out.String()
+	for _, want := range []string{"|EVENT|abc|", "|http request|method=GET|path=/brew|status=418|bytes=3|latency="} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestNewId(t *testing.T) {
+	var out bytes.Buffer
+	h := &Middleware{
+		Logger: mlog.New(&out, &out),
+		Header: "X-Req",
+		NewId:  func() string { return "fresh" },
+		Next:   http.NotFoundHandler(),
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Header().Get("X-Req") != "fresh" || !strings.Contains(out.String(), "|EVENT|fresh|") ||
+		!strings.Contains(out.String(), "|status=404|") {
+		t.Errorf("header %q, output %q", rec.Header().Get("X-Req"), out.String())
+	}
+}
+
+// records is a writer collecting the lines logged by the server
+type records chan string
+
+func (c records) Write(p []byte) (int, error) {
+	c <- string(p)
+	return len(p), nil
+}
+
+func TestFlushAndHijack(t *testing.T) {
+	out := make(records, 2)
+	l := mlog.New(out, out)
+	srv := httptest.NewServer(&Middleware{Logger: l, Next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stream" {
+			f, ok := w.(http.Flusher)
+			if !ok {
+				t.Error("ResponseWriter is not an http.Flusher")
+				return
+			}
+			io.WriteString(w, "part")
+			f.Flush()
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: test\r\nConnection: Upgrade\r\n\r\n")
+		rw.Flush()
+	})})
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "part" || resp.TransferEncoding == nil {
+		t.Errorf("body %q, transfer encoding %v; want a flushed chunked response", b, resp.TransferEncoding)
+	}
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /upgrade HTTP/1.1\r\nHost: x\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+	resp, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("upgrade status = %d", resp.StatusCode)
+	}
+
+	for _, want := range []string{"|path=/stream|status=200|bytes=4|", "|path=/upgrade|status=101|"} {
+		if got := <-out; !strings.Contains(got, want) {
+			t.Errorf("output = %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// network sink defaults
+const (
+	cnetdial       = 5 * time.Second
+	cnetwrite      = 5 * time.Second
+	cnetminbackoff = 100 * time.Millisecond
+	cnetmaxbackoff = 30 * time.Second
+	cnetspoolmax   = 64 << 20
+	cnetchunk      = 64 << 10
+)
+
+var (
+	errSinkClosed = errors.New("mlog: sink closed")
+	errConnLost   = errors.New("mlog: connection lost")
+)
+
+// A NetworkSink is a Sink shipping the text of records, one line each,
+// over TCP (with TLS if set) to a collector at Addr. Records are
+// spooled, to the file Spool or in memory if that is empty, up to
+// SpoolMax bytes; further records are dropped and counted. They are
+// sent at once while connected and kept until taken as delivered, when
+// the connection has stayed open a moment after them. The connection is
+// read to notice the collector closing it, as writes into it still
+// succeed. A background goroutine then reconnects with exponential
+// backoff between MinBackoff and MaxBackoff and replays the spool from
+// the first record not delivered. A spool file left by an earlier
+// process is replayed too. Delivery is at least once: records sent just
+// before the collector went away are sent again. The zero value with an
+// Addr is ready for use.
+type NetworkSink struct {
+	Addr       string
+	TLS        *tls.Config // nil for plain TCP
+	Spool      string      // path of the spool file, "" spools in memory
+	SpoolMax   int64       // 0 uses 64MiB
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	mu      sync.Mutex
+	smu     sync.Mutex // serializes shipping
+	conn    net.Conn
+	dead    chan struct{} // closed when conn's peer closes it
+	q       spool
+	started bool
+	closed  bool
+	wake    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	dropped uint64 // atomic
+}
+
+// Write spools text and sends it directly when connected and nothing
+// is waiting to be sent, else leaves it to the shipper.
+func (s *NetworkSink) Write(r *Record, text []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errSinkClosed
+	}
+	if !s.started {
+		s.start()
+	}
+	s.check()
+
+	limit := s.SpoolMax
+	if limit <= 0 {
+		limit = cnetspoolmax
+	}
+	n := int64(len(text))
+	if s.q.size-s.q.acked+n > limit && s.q.sent > s.q.acked {
+		// room for new records comes before replaying sent ones
+		s.q.acked = s.q.sent
+		s.q.trim()
+	}
+	var err error
+	if s.q.size-s.q.acked+n > limit {
+		atomic.AddUint64(&s.dropped, 1)
+	} else if err = s.q.append(text); err != nil {
+		atomic.AddUint64(&s.dropped, 1)
+	} else if s.conn != nil && s.q.sent == s.q.size-n {
+		s.conn.SetWriteDeadline(time.Now().Add(cnetwrite))
+		if _, werr := s.conn.Write(text); werr == nil {
+			s.q.sent += n
+		} else {
+			s.drop()
+		}
+	}
+	if s.q.sent < s.q.size {
+		s.poke()
+	}
+	return err
+}
+
+// Dropped returns the number of records discarded because the spool
+// was full or failed.
+func (s *NetworkSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Sync tries once to deliver what is spooled, connecting if needed, and
+// waits a moment for the collector to keep the connection.
+func (s *NetworkSink) Sync() error {
+	s.mu.Lock()
+	idle := s.closed || !s.started
+	s.mu.Unlock()
+	if idle {
+		return nil
+	}
+	return s.deliver(true)
+}
+
+// Close stops shipping and closes the connection, first trying once to
+// deliver records spooled in memory. Records still spooled in a file
+// are replayed by the next NetworkSink using it.
+func (s *NetworkSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	started := s.started
+	s.mu.Unlock()
+
+	var err error
+	if started {
+		close(s.stop)
+		<-s.done
+		err = s.deliver(s.q.f == nil)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if cerr := s.q.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// start opens the spool and starts the shipper
+func (s *NetworkSink) start() {
+	s.started = true
+	s.wake = make(chan struct{}, 1)
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	if s.Spool != "" {
+		if err := s.q.open(s.Spool); err != nil {
+			// fall back to memory
+			s.q = spool{}
+		}
+	}
+	// connect, and replay a spool left by an earlier process
+	s.wake <- struct{}{}
+	go s.run()
+}
+
+// poke wakes the shipper
+func (s *NetworkSink) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// check drops the connection if the collector has closed it, else
+// takes what it has outlived as delivered
+func (s *NetworkSink) check() {
+	if s.conn == nil {
+		return
+	}
+	select {
+	case <-s.dead:
+		s.drop()
+	default:
+		// a failed trim is retried by the next check
+		s.q.confirm(time.Now())
+	}
+}
+
+// drop closes the connection and rewinds the spool to the first record
+// not delivered
+func (s *NetworkSink) drop() {
+	s.conn.Close()
+	s.conn = nil
+	s.q.rewind()
+}
+
+// run connects and replays the spool whenever woken, retrying with
+// backoff until it succeeds
+func (s *NetworkSink) run() {
+	defer close(s.done)
+	minb, maxb := s.MinBackoff, s.MaxBackoff
+	if minb <= 0 {
+		minb = cnetminbackoff
+	}
+	if maxb < minb {
+		maxb = max(minb, cnetmaxbackoff)
+	}
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.wake:
+		}
+		for backoff := minb; s.ship() != nil; backoff = min(2*backoff, maxb) {
+			select {
+			case <-s.stop:
+				return
+			case <-time.After(backoff):
+			}
+		}
+	}
+}
+
+// ship connects if needed and sends what is spooled and not yet sent
+func (s *NetworkSink) ship() error {
+	s.smu.Lock()
+	defer s.smu.Unlock()
+	s.mu.Lock()
+	s.check()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		c, err := s.dial()
+		if err != nil {
+			return err
+		}
+		dead := make(chan struct{})
+		watchStream(c, func() {
+			close(dead)
+			s.poke()
+		})
+		s.mu.Lock()
+		s.conn, s.dead, conn = c, dead, c
+		s.mu.Unlock()
+	}
+
+	for {
+		s.mu.Lock()
+		if s.check(); s.conn != conn {
+			s.mu.Unlock()
+			return errConnLost
+		}
+		if s.q.sent == s.q.size {
+			s.mu.Unlock()
+			return nil
+		}
+		chunk, err := s.q.read(cnetchunk)
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(cnetwrite))
+		_, err = conn.Write(chunk)
+		s.mu.Lock()
+		switch {
+		case s.conn != conn:
+			err = errConnLost
+		case err != nil:
+			s.drop()
+		default:
+			s.q.sent += int64(len(chunk))
+		}
+		s.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// deliver ships what is spooled, connecting only if dial is set, and
+// takes it as delivered if the connection stays open a moment
+func (s *NetworkSink) deliver(dial bool) error {
+	s.mu.Lock()
+	pending := s.q.acked < s.q.size
+	connected := s.conn != nil
+	s.mu.Unlock()
+	if !pending || (!dial && !connected) {
+		return nil
+	}
+	if err := s.ship(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	conn, dead, end := s.conn, s.dead, s.q.base+s.q.sent
+	s.mu.Unlock()
+	select {
+	case <-dead:
+	case <-time.After(cdelivered):
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.check(); s.conn != conn {
+		return errConnLost
+	}
+	s.q.acked = max(s.q.acked, end-s.q.base)
+	return s.q.trim()
+}
+
+func (s *NetworkSink) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: cnetdial}
+	if s.TLS != nil {
+		return tls.DialWithDialer(d, "tcp", s.Addr, s.TLS)
+	}
+	return d.Dial("tcp", s.Addr)
+}
+
+// A spool is the queue of records, in a file or in memory. The bytes
+// before sent have been written to the connection and those before
+// acked are taken as delivered; they are removed from the front once
+// they make up half of it, or all of it.
+type spool struct {
+	f      *os.File
+	mem    []byte
+	base   int64 // bytes removed from the front
+	size   int64
+	sent   int64
+	acked  int64
+	mark   int64     // sent when the connection was last seen open
+	markAt time.Time // and when
+}
+
+func (q *spool) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	q.f, q.size = f, fi.Size()
+	return nil
+}
+
+func (q *spool) append(p []byte) error {
+	if q.f == nil {
+		q.mem = append(q.mem, p...)
+		q.size += int64(len(p))
+		return nil
+	}
+	n, err := q.f.WriteAt(p, q.size)
+	q.size += int64(n)
+	return err
+}
+
+// read returns unsent records, about n bytes of them; a replay must
+// not start within a record
+func (q *spool) read(n int64) ([]byte, error) {
+	for {
+		b, err := q.readAt(q.sent, min(n, q.size-q.sent))
+		if err != nil {
+			return nil, err
+		}
+		if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+			return b[:i+1], nil
+		}
+		if int64(len(b)) == q.size-q.sent {
+			return b, nil
+		}
+		n *= 2
+	}
+}
+
+func (q *spool) readAt(off, n int64) ([]byte, error) {
+	if q.f == nil {
+		return append([]byte(nil), q.mem[off:off+n]...), nil
+	}
+	b := make([]byte, n)
+	_, err := q.f.ReadAt(b, off)
+	return b, err
+}
+
+// confirm takes the bytes sent as delivered once the connection has
+// been seen open cdelivered after sending them
+func (q *spool) confirm(now time.Time) error {
+	if q.mark > q.acked && now.Sub(q.markAt) >= cdelivered {
+		q.acked = q.mark
+	}
+	if q.mark <= q.acked && q.sent > q.acked {
+		q.mark, q.markAt = q.sent, now
+	}
+	return q.trim()
+}
+
+// rewind resends from the first byte not delivered
+func (q *spool) rewind() {
+	q.sent, q.mark = q.acked, q.acked
+}
+
+// trim removes delivered bytes from the front
+func (q *spool) trim() error {
+	switch {
+	case q.acked == q.size:
+		return q.reset()
+	case q.acked < cnetchunk || 2*q.acked < q.size:
+		return nil
+	}
+	rest, err := q.readAt(q.acked, q.size-q.acked)
+	if err != nil {
+		return err
+	}
+	if q.f == nil {
+		q.mem = rest
+	} else if _, err = q.f.WriteAt(rest, 0); err == nil {
+		err = q.f.Truncate(int64(len(rest)))
+	}
+	q.base += q.acked
+	q.size -= q.acked
+	q.sent -= q.acked
+	q.mark = max(q.mark-q.acked, 0)
+	q.acked = 0
+	return err
+}
+
+// reset empties a delivered spool
+func (q *spool) reset() error {
+	q.base += q.size
+	q.mem, q.size, q.sent, q.acked, q.mark = nil, 0, 0, 0, 0
+	if q.f == nil {
+		return nil
+	}
+	return q.f.Truncate(0)
+}
+
+// close closes the file, dropping what has been delivered from it
+func (q *spool) close() error {
+	if q.f == nil {
+		return nil
+	}
+	var err error
+	if q.acked > 0 {
+		// keep only the undelivered tail for the next process
+		var rest []byte
+		if rest, err = q.readAt(q.acked, q.size-q.acked); err == nil {
+			if err = q.f.Truncate(0); err == nil {
+				_, err = q.f.WriteAt(rest, 0)
+			}
+		}
+	}
+	if cerr := q.f.Close(); err == nil {
+		err = cerr
+	}
+	q.f = nil
+	return err
+}
+
+// openNetwork opens a route's network sink, tcp:HOST:PORT or
+// tls:HOST:PORT, spooling in a file of the LRT_MLOGSPOOL directory
+func openNetwork(scheme, addr string) (Sink, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	s := &NetworkSink{Addr: addr, SpoolMax: ext.MlogSpoolMax}
+	if scheme == "tls" {
+		host, _, _ := net.SplitHostPort(addr)
+		s.TLS = &tls.Config{ServerName: host}
+	}
+	if ext.MlogSpool != "" {
+		name := strings.NewReplacer(":", "_", "/", "_", "[", "", "]", "").Replace(addr)
+		s.Spool = filepath.Join(ext.MlogSpool, scheme+"-"+name+".spool")
+	}
+	return s, nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// collect accepts one connection on ln and sends its lines to the channel
+func collect(t *testing.T, ln net.Listener) <-chan string {
+	lines := make(chan string, 100)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		sc := bufio.NewScanner(c)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+	return lines
+}
+
+func expectLines(t *testing.T, lines <-chan string, want ...string) {
+	for _, w := range want {
+		select {
+		case got := <-lines:
+			if got != w {
+				t.Errorf("line = %q, want %q", got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", w)
+		}
+	}
+}
+
+func TestNetworkSpool(t *testing.T) {
+	// find a free port, leaving the collector down
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	spool := filepath.Join(t.TempDir(), "ship.spool")
+	s := &NetworkSink{Addr: addr, Spool: spool, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	l := New(nil, nil)
+	l.SetSuppress(true)
+	l.SetRoute(INFO, s)
+	for i := 0; i < 3; i++ {
+		l.Info("down %d", i)
+	}
+	if fi, err := os.Stat(spool); err != nil || fi.Size() == 0 {
+		t.Fatalf("spool not written: %v", err)
+	}
+
+	// the collector comes up; the spool is replayed then records flow
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	lines := collect(t, ln)
+	expectLines(t, lines, "*2|7|INFO||||||down 0", "*2|7|INFO||||||down 1", "*2|7|INFO||||||down 2")
+	l.Info("up")
+	expectLines(t, lines, "*2|7|INFO||||||up")
+
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(spool); err != nil || fi.Size() != 0 {
+		t.Errorf("spool not emptied: %v, %v", fi.Size(), err)
+	}
+}
+
+func TestNetworkSpoolLeftover(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	lines := collect(t, ln)
+
+	spool := filepath.Join(t.TempDir(), "ship.spool")
+	os.WriteFile(spool, []byte("old 1\nold 2\n"), 0644)
+	s := &NetworkSink{Addr: ln.Addr().String(), Spool: spool, SpoolMax: 20}
+	defer s.Close()
+	s.Write(nil, []byte("new\n"))
+	s.Write(nil, []byte(fmt.Sprintf("%030d\n", 0))) // too big for the spool
+	expectLines(t, lines, "old 1", "old 2", "new")
+	if s.Dropped() != 1 {
+		t.Errorf("dropped = %d, want 1", s.Dropped())
+	}
+}
+
+func TestNetworkCollectorRestart(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	addr := ln.Addr().String()
+	// the collector takes one record and goes down
+	first := make(chan string, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		line, _ := bufio.NewReader(c).ReadString('\n')
+		c.Close()
+		ln.Close()
+		first <- line
+	}()
+
+	s := &NetworkSink{Addr: addr, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	l := New(nil, nil)
+	l.SetSuppress(true)
+	l.SetRoute(INFO, s)
+	defer l.Close()
+	l.Info("a")
+	if got := <-first; got != "*2|7|INFO||||||a\n" {
+		t.Fatalf("first line = %q", got)
+	}
+	l.Info("b")
+	l.Info("c")
+
+	// it comes back up; a, sent just before it went, may come again
+	if ln, err = net.Listen("tcp", addr); err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+	lines := collect(t, ln)
+	select {
+	case got := <-lines:
+		if got != "*2|7|INFO||||||a" && got != "*2|7|INFO||||||b" {
+			t.Errorf("line = %q, want a or b", got)
+		}
+		if got == "*2|7|INFO||||||a" {
+			expectLines(t, lines, "*2|7|INFO||||||b")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for b")
+	}
+	expectLines(t, lines, "*2|7|INFO||||||c")
+	l.Info("d")
+	expectLines(t, lines, "*2|7|INFO||||||d")
+}
+
+func TestSpoolTrim(t *testing.T) {
+	var q spool
+	rec := fmt.Sprintf("%0*d\n", cnetchunk/4-1, 0)
+	for i := 0; i < 8; i++ {
+		q.append([]byte(rec))
+	}
+	b, _ := q.read(cnetchunk + 10)
+	if len(b) != cnetchunk {
+		t.Fatalf("read %d bytes, want whole records", len(b))
+	}
+	q.sent = int64(len(b))
+	q.confirm(time.Now())
+	q.confirm(time.Now().Add(cdelivered))
+	if q.acked != 0 || q.base != cnetchunk || q.size != cnetchunk || q.sent != 0 {
+		t.Errorf("after trim base %d size %d sent %d acked %d", q.base, q.size, q.sent, q.acked)
+	}
+	q.sent = 10
+	q.rewind()
+	if b, _ := q.read(cnetchunk); q.sent != 0 || string(b[:len(rec)]) != rec {
+		t.Errorf("rewound to %d", q.sent)
+	}
+}
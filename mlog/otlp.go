@@ -0,0 +1,362 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OTLP exporter defaults
+const (
+	cotlpbatch    = 512
+	cotlpinterval = time.Second
+	cotlptimeout  = 10 * time.Second
+	cotlppending  = 16 // batches held while posting is slow
+	cotlpscope    = "github.com/lavaorg/lrt/mlog"
+)
+
+// OpenTelemetry severity number of each mlog severity
+var otlpsev = [UNKNOWN + 1]int{
+	ALARM:   21, // FATAL
+	ERROR:   17, // ERROR
+	STAT:    11, // INFO3
+	EVENT:   10, // INFO2
+	INFO:    9,  // INFO
+	DEBUG:   5,  // DEBUG
+	UNKNOWN: 0,  // unspecified
+}
+
+// An OTLPSink is a Sink exporting records in the OpenTelemetry logs data
+// model, JSON encoded, to an OTLP/HTTP Endpoint such as
+// http://localhost:4318/v1/logs. Records are batched and posted when
+// BatchSize are pending or every Interval. The process name and pid are
+// resource attributes, the source and fields record attributes, and the
+// correlation id is the attribute correlation.id and, when it is 32 hex
+// digits, the trace id. Batches that cannot be posted are dropped and
+// counted. The zero value with an Endpoint is ready for use.
+type OTLPSink struct {
+	Endpoint  string
+	Headers   map[string]string // added to each request
+	Client    *http.Client      // nil uses a client with a 10s timeout
+	BatchSize int               // 0 uses 512
+	Interval  time.Duration     // 0 uses 1s
+
+	mu      sync.Mutex
+	batch   []*Record
+	started bool
+	closed  bool
+	full    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	dropped uint64 // atomic
+}
+
+// Write queues the record for export; text is not used.
+func (s *OTLPSink) Write(r *Record, text []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errSinkClosed
+	}
+	if !s.started {
+		s.start()
+	}
+	size := s.batchSize()
+	if len(s.batch) >= size*cotlppending {
+		atomic.AddUint64(&s.dropped, 1)
+		return nil
+	}
+	s.batch = append(s.batch, r)
+	if len(s.batch) >= size {
+		select {
+		case s.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Flush posts the pending records.
+func (s *OTLPSink) Flush() error {
+	s.mu.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.mu.Unlock()
+
+	var err error
+	for size := s.batchSize(); len(batch) > 0; batch = batch[min(size, len(batch)):] {
+		if perr := s.post(batch[:min(size, len(batch))]); perr != nil && err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+// Sync posts the pending records, as Flush.
+func (s *OTLPSink) Sync() error {
+	return s.Flush()
+}
+
+// Dropped returns the number of records that could not be exported.
+func (s *OTLPSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Close stops the exporter after posting the pending records.
+func (s *OTLPSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	started := s.started
+	s.mu.Unlock()
+
+	if started {
+		close(s.stop)
+		<-s.done
+	}
+	return s.Flush()
+}
+
+func (s *OTLPSink) batchSize() int {
+	if s.BatchSize > 0 {
+		return s.BatchSize
+	}
+	return cotlpbatch
+}
+
+func (s *OTLPSink) start() {
+	s.started = true
+	s.full = make(chan struct{}, 1)
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	interval := s.Interval
+	if interval <= 0 {
+		interval = cotlpinterval
+	}
+	go s.run(interval)
+}
+
+// run posts batches when full or due
+func (s *OTLPSink) run(interval time.Duration) {
+	defer close(s.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.full:
+		case <-t.C:
+		}
+		s.Flush()
+	}
+}
+
+// post sends one export request
+func (s *OTLPSink) post(batch []*Record) error {
+	var b bytes.Buffer
+	if err := json.NewEncoder(&b).Encode(otlpRequest(batch)); err != nil {
+		atomic.AddUint64(&s.dropped, uint64(len(batch)))
+		return err
+	}
+	req, err := http.NewRequest("POST", s.Endpoint, &b)
+	if err != nil {
+		atomic.AddUint64(&s.dropped, uint64(len(batch)))
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: cotlptimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		atomic.AddUint64(&s.dropped, uint64(len(batch)))
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		atomic.AddUint64(&s.dropped, uint64(len(batch)))
+		return fmt.Errorf("mlog: OTLP export to %s: %s", s.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// the OTLP JSON encoding of ExportLogsServiceRequest, as far as used
+type (
+	otlpExport struct {
+		ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+	}
+	otlpResourceLogs struct {
+		Resource  otlpResource    `json:"resource"`
+		ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttr `json:"attributes"`
+	}
+	otlpScopeLogs struct {
+		Scope      otlpScope       `json:"scope"`
+		LogRecords []otlpLogRecord `json:"logRecords"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpLogRecord struct {
+		TimeUnixNano         string     `json:"timeUnixNano"`
+		ObservedTimeUnixNano string     `json:"observedTimeUnixNano"`
+		SeverityNumber       int        `json:"severityNumber,omitempty"`
+		SeverityText         string     `json:"severityText"`
+		Body                 otlpValue  `json:"body"`
+		Attributes           []otlpAttr `json:"attributes,omitempty"`
+		TraceId              string     `json:"traceId,omitempty"`
+	}
+	otlpAttr struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"` // int64 is a JSON string
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// otlpRequest groups the batch by process into an export request
+func otlpRequest(batch []*Record) *otlpExport {
+	req := &otlpExport{}
+	index := map[string]int{}
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	for _, r := range batch {
+		key := r.Name + "\x00" + r.Pid
+		i, ok := index[key]
+		if !ok {
+			i = len(req.ResourceLogs)
+			index[key] = i
+			attrs := []otlpAttr{
+				{"service.name", otlpString(r.Name)},
+				{"process.executable.name", otlpString(r.Name)},
+			}
+			if pid, err := strconv.ParseInt(r.Pid, 10, 64); err == nil {
+				attrs = append(attrs, otlpAttr{"process.pid", otlpValueOf(pid)})
+			}
+			req.ResourceLogs = append(req.ResourceLogs, otlpResourceLogs{
+				Resource:  otlpResource{Attributes: attrs},
+				ScopeLogs: []otlpScopeLogs{{Scope: otlpScope{Name: cotlpscope}}},
+			})
+		}
+		sl := &req.ResourceLogs[i].ScopeLogs[0]
+		sl.LogRecords = append(sl.LogRecords, otlpRecord(r, now))
+	}
+	return req
+}
+
+func otlpRecord(r *Record, observed string) otlpLogRecord {
+	sev := min(r.Sev, UNKNOWN)
+	lr := otlpLogRecord{
+		TimeUnixNano:         strconv.FormatInt(r.Time.UnixNano(), 10),
+		ObservedTimeUnixNano: observed,
+		SeverityNumber:       otlpsev[sev],
+		SeverityText:         sevstr[sev],
+		Body:                 otlpString(r.Msg),
+		Attributes: []otlpAttr{
+			{"code.filepath", otlpString(r.File)},
+			{"code.lineno", otlpValueOf(r.Line)},
+		},
+	}
+	if r.CorelationId != "" {
+		lr.Attributes = append(lr.Attributes, otlpAttr{"correlation.id", otlpString(r.CorelationId)})
+		if id := strings.ToLower(r.CorelationId); len(id) == 32 && strings.Trim(id, "0") != "" {
+			if _, err := hex.DecodeString(id); err == nil {
+				lr.TraceId = id
+			}
+		}
+	}
+	for _, f := range r.Fields {
+		lr.Attributes = append(lr.Attributes, otlpAttr{f.Key, otlpValueOf(f.Value)})
+	}
+	if len(r.Stack) > 0 {
+		lr.Attributes = append(lr.Attributes, otlpAttr{"code.stacktrace", otlpString(formatStack(r.Stack))})
+	}
+	return lr
+}
+
+func otlpString(s string) otlpValue {
+	return otlpValue{StringValue: &s}
+}
+
+// otlpValueOf maps booleans, integers and floats to their OTLP types
+// and anything else to its fmt representation
+func otlpValueOf(v interface{}) otlpValue {
+	var i int64
+	switch x := v.(type) {
+	case bool:
+		return otlpValue{BoolValue: &x}
+	case float32:
+		return otlpValueOf(float64(x))
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			// not representable in JSON
+			return otlpString(fmt.Sprint(x))
+		}
+		return otlpValue{DoubleValue: &x}
+	case int:
+		i = int64(x)
+	case int8:
+		i = int64(x)
+	case int16:
+		i = int64(x)
+	case int32:
+		i = int64(x)
+	case int64:
+		i = x
+	case uint8:
+		i = int64(x)
+	case uint16:
+		i = int64(x)
+	case uint32:
+		i = int64(x)
+	case error:
+		return otlpString(x.Error())
+	default:
+		return otlpString(fmt.Sprint(v))
+	}
+	s := strconv.FormatInt(i, 10)
+	return otlpValue{IntValue: &s}
+}
+
+// openOTLP opens a route's OTLP sink, otlp:URL, with the headers of
+// LRT_MLOGOTLPHEADERS
+func openOTLP(endpoint string) (Sink, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("mlog: bad OTLP endpoint %q", endpoint)
+	}
+	s := &OTLPSink{Endpoint: endpoint, Headers: map[string]string{}}
+	for _, h := range strings.Split(ext.MlogOtlpHeaders, ",") {
+		if k, v, ok := strings.Cut(h, "="); ok {
+			s.Headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return s, nil
+}
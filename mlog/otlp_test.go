@@ -0,0 +1,105 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOTLP(t *testing.T) {
+	requests := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Key") != "k" {
+			t.Errorf("request %s %v", r.URL.Path, r.Header)
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		requests <- body
+	}))
+	defer srv.Close()
+
+	s := &OTLPSink{Endpoint: srv.URL + "/v1/logs", Headers: map[string]string{"X-Key": "k"}, BatchSize: 2, Interval: time.Hour}
+	l := New(nil, nil)
+	l.SetCorelationId("4bf92f3577b34da6a3ce929d0e0e4736")
+	l.SetRoute(ERROR, s)
+	l.SetRoute(INFO, s)
+	l.ErrorKV("failed", "n", 3, "ok", false)
+	l.Info("second")
+
+	var body map[string]interface{}
+	select {
+	case body = <-requests:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no export after a full batch")
+	}
+	rl := body["resourceLogs"].([]interface{})[0].(map[string]interface{})
+	res, _ := json.Marshal(rl["resource"])
+	if want := `{"attributes":[{"key":"service.name","value":{"stringValue":"` + name +
+		`"}},{"key":"process.executable.name","value":{"stringValue":"` + name +
+		`"}},{"key":"process.pid","value":{"intValue":"` + pid + `"}}]}`; string(res) != want {
+		t.Errorf("resource = %s\nwant %s", res, want)
+	}
+	recs := rl["scopeLogs"].([]interface{})[0].(map[string]interface{})["logRecords"].([]interface{})
+	if len(recs) != 2 {
+		t.Fatalf("records = %v", recs)
+	}
+	first := recs[0].(map[string]interface{})
+	if first["severityNumber"] != 17.0 || first["severityText"] != "ERROR" ||
+		first["traceId"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("record = %v", first)
+	}
+	attrs, _ := json.Marshal(first["attributes"].([]interface{})[2:])
+	if want := `[{"key":"correlation.id","value":{"stringValue":"4bf92f3577b34da6a3ce929d0e0e4736"}},` +
+		`{"key":"n","value":{"intValue":"3"}},{"key":"ok","value":{"boolValue":false}}]`; string(attrs) != want {
+		t.Errorf("attributes = %s\nwant %s", attrs, want)
+	}
+
+	l.Info("on close")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if body = <-requests; body == nil || s.Dropped() != 0 {
+		t.Errorf("close did not export, dropped %d", s.Dropped())
+	}
+}
+
+func TestOTLPRecoverExit(t *testing.T) {
+	if url := os.Getenv("MLOG_TEST_OTLP"); url != "" {
+		l := New(nil, nil)
+		l.SetRoute(ALARM, &OTLPSink{Endpoint: url, Interval: time.Hour})
+		l.SetPanicAction(Exit, 3)
+		defer l.Recover()
+		panic("boom")
+	}
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies <- string(b)
+	}))
+	defer srv.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestOTLPRecoverExit$")
+	cmd.Env = append(os.Environ(), "MLOG_TEST_OTLP="+srv.URL)
+	if err, ok := cmd.Run().(*exec.ExitError); !ok || err.ExitCode() != 3 {
+		t.Fatalf("exit = %v, want status 3", err)
+	}
+	select {
+	case b := <-bodies:
+		if !strings.Contains(b, "panic: boom") || !strings.Contains(b, `"severityText":"ALARM"`) {
+			t.Errorf("export = %s", b)
+		}
+	default:
+		t.Fatal("the ALARM was not exported before exiting")
+	}
+}
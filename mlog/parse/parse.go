@@ -0,0 +1,424 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+// Package parse reads records written in the mlog wire format, versions
+// 1 and 2 (see mlog/FORMAT.md).
+//
+// A full version 1 record is
+//
+//	*1|SEV|CORELATIONID|PID|NAME|FILE:LINE|TIMESTAMP|MESSAGE
+//
+// and the short form written when MlogSuppress is set is
+//
+//	*1|SEV|MESSAGE
+//
+// Fields added with the mlog KV functions trail the message as a brace
+// enclosed list of key=value pairs: "MESSAGE {user=42 path="/a b"}".
+// The list is not marked, so a message that itself ends in one, such as
+// "config {a=b}", decodes with that text as fields; use version 2 where
+// fields must survive exactly.
+//
+// A version 2 record carries a count of the fields that follow it, has
+// every field escaped and gives each key=value pair its own field:
+//
+//	*2|N|SEV|CORELATIONID|PID|NAME|FILE:LINE|TIMESTAMP|MESSAGE[|KEY=VALUE]...
+//
+// Its short form leaves CORELATIONID through TIMESTAMP empty.
+//
+// A multi-line message written in continuation mode is a record whose
+// marker carries a sequence number, "*2#SEQ", holding the first line,
+// followed by lines "*2+SEQ|LINE" holding the rest. The Decoder joins
+// them into one Record.
+package parse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// wire format constants; these track the mlog package
+const (
+	Version    = "2" // highest wire version understood
+	cmarker    = "*"
+	cseparator = "|"
+	ctmformat  = "2006/01/02 15:04:05.999999"
+	cfields2   = 7 // fields of a version 2 record before any key=value
+)
+
+// A Record is a single decoded line.
+type Record struct {
+	Version      string
+	Seq          uint64 // sequence number of a continued record, else 0
+	Short        bool   // the MlogSuppress form, only Sev and Msg are set
+	Sev          string
+	CorelationId string
+	Pid          string
+	Name         string
+	File         string
+	Line         int
+	Time         time.Time
+	Msg          string
+	Fields       []Field
+}
+
+// A Field is a key/value pair trailing the message.
+type Field struct {
+	Key   string
+	Value string
+}
+
+// Get returns the value of the named field.
+func (r *Record) Get(key string) (string, bool) {
+	for _, f := range r.Fields {
+		if f.Key == key {
+			return f.Value, true
+		}
+	}
+	return "", false
+}
+
+// A SyntaxError describes a line that is not a valid record.
+type SyntaxError struct {
+	Offset int64  // byte offset of the start of the line
+	Line   int    // 1 based line number
+	Text   string // the offending line
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("mlog/parse: line %d (offset %d): %s", e.Line, e.Offset, e.Msg)
+}
+
+// A Decoder reads records from an input stream.
+type Decoder struct {
+	r      *bufio.Reader
+	offset int64 // offset of the next line
+	line   int   // number of lines read
+	peeked *text // line read ahead looking for continuations
+}
+
+// a line of input and where it started
+type text struct {
+	s      string
+	offset int64
+	line   int
+}
+
+// NewDecoder returns a Decoder reading from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode returns the next record. Blank lines are skipped. A malformed
+// line is reported as a *SyntaxError and decoding may continue with the
+// next line. At the end of the input Decode returns io.EOF.
+func (d *Decoder) Decode() (*Record, error) {
+	t, err := d.next()
+	if err != nil {
+		return nil, err
+	}
+	rec, err := ParseLine(t.s)
+	if err != nil {
+		return nil, &SyntaxError{Offset: t.offset, Line: t.line, Text: t.s, Msg: err.Error()}
+	}
+	if rec.Seq == 0 {
+		return rec, nil
+	}
+
+	// gather the continuation lines
+	prefix := cmarker + rec.Version + "+" + strconv.FormatUint(rec.Seq, 10) + cseparator
+	for {
+		c, err := d.next()
+		if err != nil {
+			return rec, nil
+		}
+		if !strings.HasPrefix(c.s, prefix) {
+			d.peeked = c
+			return rec, nil
+		}
+		line := c.s[len(prefix):]
+		if rec.Version != "1" {
+			if line, err = unescape(line); err != nil {
+				return nil, &SyntaxError{Offset: c.offset, Line: c.line, Text: c.s, Msg: err.Error()}
+			}
+		}
+		rec.Msg += "\n" + line
+	}
+}
+
+// next returns the next non-blank line
+func (d *Decoder) next() (*text, error) {
+	if t := d.peeked; t != nil {
+		d.peeked = nil
+		return t, nil
+	}
+	for {
+		s, err := d.r.ReadString('\n')
+		if s == "" && err != nil {
+			return nil, err
+		}
+		t := &text{offset: d.offset, line: d.line + 1}
+		d.offset += int64(len(s))
+		d.line++
+
+		t.s = strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+		if t.s != "" {
+			return t, nil
+		}
+	}
+}
+
+// InputOffset returns the byte offset of the next line to be read.
+func (d *Decoder) InputOffset() int64 {
+	if d.peeked != nil {
+		return d.peeked.offset
+	}
+	return d.offset
+}
+
+// ParseLine decodes a single line without its trailing newline.
+func ParseLine(text string) (*Record, error) {
+	if !strings.HasPrefix(text, cmarker) {
+		return nil, fmt.Errorf("missing %q marker", cmarker)
+	}
+	i := strings.Index(text, cseparator)
+	if i < 0 {
+		return nil, fmt.Errorf("missing separator")
+	}
+	version := text[len(cmarker):i]
+	var seq uint64
+	if j := strings.IndexAny(version, "#+"); j >= 0 {
+		if version[j] == '+' {
+			return nil, fmt.Errorf("continuation line without its record")
+		}
+		n, err := strconv.ParseUint(version[j+1:], 10, 64)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("bad sequence number %q", version[j+1:])
+		}
+		version, seq = version[:j], n
+	}
+	switch version {
+	case "1":
+	case "2":
+		rec, err := parse2(text[i+1:])
+		if rec != nil {
+			rec.Seq = seq
+		}
+		return rec, err
+	default:
+		return nil, fmt.Errorf("unsupported version %q", version)
+	}
+
+	x := strings.SplitN(text[i+1:], cseparator, 7)
+	if len(x) < 2 {
+		return nil, fmt.Errorf("missing message")
+	}
+	rec := &Record{Version: version, Seq: seq, Sev: x[0]}
+	if !isSeverity(rec.Sev) {
+		return nil, fmt.Errorf("bad severity %q", rec.Sev)
+	}
+	if !parseHeader(rec, x) {
+		// short form; the message is everything after the severity
+		rec.Short = true
+		rec.Msg = text[i+1+len(rec.Sev)+1:]
+	}
+	rec.Msg, rec.Fields = splitFields(rec.Msg)
+	return rec, nil
+}
+
+// parseHeader fills in the full form header if x looks like one
+func parseHeader(rec *Record, x []string) bool {
+	if len(x) != 7 {
+		return false
+	}
+	j := strings.LastIndex(x[4], ":")
+	if j < 0 {
+		return false
+	}
+	line, err := strconv.Atoi(x[4][j+1:])
+	if err != nil {
+		return false
+	}
+	if _, err := strconv.Atoi(x[2]); err != nil {
+		return false
+	}
+	ts, err := time.Parse(ctmformat, x[5])
+	if err != nil {
+		return false
+	}
+	rec.CorelationId = x[1]
+	rec.Pid = x[2]
+	rec.Name = x[3]
+	rec.File = x[4][:j]
+	rec.Line = line
+	rec.Time = ts
+	rec.Msg = x[6]
+	return true
+}
+
+// parse2 decodes the part of a version 2 record after the marker
+func parse2(text string) (*Record, error) {
+	x := strings.Split(text, cseparator)
+	n, err := strconv.Atoi(x[0])
+	if err != nil {
+		return nil, fmt.Errorf("bad field count %q", x[0])
+	}
+	x = x[1:]
+	if n != len(x) || n < cfields2 {
+		return nil, fmt.Errorf("field count %d, found %d", n, len(x))
+	}
+	for i := range x[:cfields2] {
+		if x[i], err = unescape(x[i]); err != nil {
+			return nil, err
+		}
+	}
+	rec := &Record{Version: "2", Sev: x[0], Msg: x[6]}
+	if !isSeverity(rec.Sev) {
+		return nil, fmt.Errorf("bad severity %q", rec.Sev)
+	}
+	if x[1]+x[2]+x[3]+x[4]+x[5] == "" {
+		rec.Short = true
+	} else {
+		j := strings.LastIndex(x[4], ":")
+		if j < 0 {
+			return nil, fmt.Errorf("bad source %q", x[4])
+		}
+		if rec.Line, err = strconv.Atoi(x[4][j+1:]); err != nil {
+			return nil, fmt.Errorf("bad source %q", x[4])
+		}
+		if rec.Time, err = time.Parse(ctmformat, x[5]); err != nil {
+			return nil, fmt.Errorf("bad timestamp %q", x[5])
+		}
+		rec.CorelationId, rec.Pid, rec.Name, rec.File = x[1], x[2], x[3], x[4][:j]
+	}
+	// keys have '=' escaped so split before unescaping
+	for _, kv := range x[cfields2:] {
+		eq := strings.IndexByte(kv, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("bad field %q", kv)
+		}
+		var f Field
+		if f.Key, err = unescape(kv[:eq]); err != nil {
+			return nil, err
+		}
+		if f.Value, err = unescape(kv[eq+1:]); err != nil {
+			return nil, err
+		}
+		rec.Fields = append(rec.Fields, f)
+	}
+	return rec, nil
+}
+
+// unescape reverses the version 2 escaping of a field
+func unescape(s string) (string, error) {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 == len(s) {
+			return "", fmt.Errorf("truncated escape in %q", s)
+		}
+		i++
+		switch s[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'x':
+			if i+2 >= len(s) {
+				return "", fmt.Errorf("truncated escape in %q", s)
+			}
+			c, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("bad escape in %q", s)
+			}
+			b.WriteByte(byte(c))
+			i += 2
+		default:
+			return "", fmt.Errorf("bad escape in %q", s)
+		}
+	}
+	return b.String(), nil
+}
+
+func isSeverity(s string) bool {
+	switch s {
+	case "ALARM", "ERROR", "STAT", "EVENT", "INFO", "DEBUG", "UNKNOWN":
+		return true
+	}
+	return false
+}
+
+// splitFields separates a trailing "{k=v ...}" block from the message.
+// The block starts the message or follows a space.
+func splitFields(m string) (string, []Field) {
+	if !strings.HasSuffix(m, "}") {
+		return m, nil
+	}
+	for i := 0; i < len(m); i++ {
+		if m[i] != '{' || (i > 0 && m[i-1] != ' ') {
+			continue
+		}
+		if fs, ok := parseFields(m[i+1 : len(m)-1]); ok {
+			if i > 0 {
+				i--
+			}
+			return m[:i], fs
+		}
+	}
+	return m, nil
+}
+
+// parseFields parses space separated key=value pairs where a value
+// is either bare or a Go quoted string.
+func parseFields(s string) ([]Field, bool) {
+	var fs []Field
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq <= 0 || strings.ContainsAny(s[:eq], " {}|\"\\") {
+			return nil, false
+		}
+		f := Field{Key: s[:eq]}
+		s = s[eq+1:]
+		if strings.HasPrefix(s, `"`) {
+			q, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, false
+			}
+			f.Value, _ = strconv.Unquote(q)
+			s = s[len(q):]
+		} else {
+			end := strings.IndexByte(s, ' ')
+			if end < 0 {
+				end = len(s)
+			}
+			f.Value = s[:end]
+			if f.Value == "" || strings.ContainsAny(f.Value, "={}|\\") {
+				return nil, false
+			}
+			s = s[end:]
+		}
+		fs = append(fs, f)
+		if s == "" {
+			break
+		}
+		if s[0] != ' ' || len(s) == 1 {
+			return nil, false
+		}
+		s = s[1:]
+	}
+	return fs, len(fs) > 0
+}
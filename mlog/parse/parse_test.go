@@ -0,0 +1,170 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package parse
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/lavaorg/lrt/mlog"
+)
+
+func TestRoundTrip(t *testing.T) {
+	for _, wire := range []int{2, 1} {
+		var buf bytes.Buffer
+		l := mlog.New(&buf, &buf)
+		l.SetWireVersion(wire)
+		l.SetCorelationId("c1")
+		l.InfoKV("hello|world {x}", "user", 42, "path", "/a b")
+		l.SetSuppress(true)
+		l.Error("short|form")
+
+		d := NewDecoder(&buf)
+		r, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Short || r.Sev != "INFO" || r.CorelationId != "c1" || r.File != "parse_test.go" || r.Line == 0 || r.Time.IsZero() {
+			t.Errorf("v%d: bad header %+v", wire, r)
+		}
+		if r.Msg != "hello|world {x}" {
+			t.Errorf("v%d: msg = %q", wire, r.Msg)
+		}
+		if v, _ := r.Get("path"); v != "/a b" || len(r.Fields) != 2 {
+			t.Errorf("v%d: fields = %v", wire, r.Fields)
+		}
+
+		r, err = d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !r.Short || r.Sev != "ERROR" || r.Msg != "short|form" {
+			t.Errorf("v%d: bad short record %+v", wire, r)
+		}
+		if _, err = d.Decode(); err != io.EOF {
+			t.Errorf("v%d: err = %v, want EOF", wire, err)
+		}
+	}
+}
+
+func TestContinuation(t *testing.T) {
+	for _, wire := range []int{2, 1} {
+		var buf bytes.Buffer
+		l := mlog.New(&buf, &buf)
+		l.SetWireVersion(wire)
+		l.SetMultiline(mlog.MultilineContinue)
+		l.ErrorKV("failed\n\n  at step|1", "n", 1)
+		l.Info("next")
+
+		d := NewDecoder(&buf)
+		r, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Seq == 0 || r.Msg != "failed\n\n  at step|1" || len(r.Fields) != 1 {
+			t.Errorf("v%d: bad record %+v", wire, r)
+		}
+		if r, err = d.Decode(); err != nil || r.Msg != "next" || r.Seq != 0 {
+			t.Errorf("v%d: next = %+v, %v", wire, r, err)
+		}
+	}
+
+	d := NewDecoder(strings.NewReader("*2+3|orphan\n"))
+	if _, err := d.Decode(); err == nil {
+		t.Error("orphan continuation line accepted")
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	var buf bytes.Buffer
+	l := mlog.New(&buf, &buf)
+	l.SetCorelationId("up|stream\\id")
+	msg := "multi\nline\ttab|pipe\x00nul\\"
+	l.InfoKV(msg, "k=ey", "v|a\nl")
+
+	if n := strings.Count(buf.String(), "\n"); n != 1 {
+		t.Fatalf("record spans %d lines: %q", n, buf.String())
+	}
+	r, err := ParseLine(strings.TrimSuffix(buf.String(), "\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.CorelationId != "up|stream\\id" || r.Msg != msg {
+		t.Errorf("got corr %q msg %q", r.CorelationId, r.Msg)
+	}
+	if len(r.Fields) != 1 || r.Fields[0] != (Field{"k=ey", "v|a\nl"}) {
+		t.Errorf("fields = %q", r.Fields)
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	in := "*1|INFO|ok\n\nnot a record\n*9|INFO|future\n*1|LOUD|x\n*2|8|INFO||||||cut\n*2|7|DEBUG||||||fine\n"
+	d := NewDecoder(strings.NewReader(in))
+
+	want := []struct {
+		line   int
+		offset int64
+	}{{3, 12}, {4, 25}, {5, 40}, {6, 50}}
+	if r, err := d.Decode(); err != nil || r.Msg != "ok" {
+		t.Fatalf("got %v, %v", r, err)
+	}
+	for _, w := range want {
+		_, err := d.Decode()
+		var se *SyntaxError
+		if !errors.As(err, &se) {
+			t.Fatalf("err = %v, want SyntaxError", err)
+		}
+		if se.Line != w.line || se.Offset != w.offset {
+			t.Errorf("error at line %d offset %d, want %d %d", se.Line, se.Offset, w.line, w.offset)
+		}
+	}
+	if r, err := d.Decode(); err != nil || r.Sev != "DEBUG" {
+		t.Fatalf("got %v, %v", r, err)
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	tests := []struct {
+		in, msg string
+		n       int
+	}{
+		{"plain", "plain", 0},
+		{"a {b}", "a {b}", 0},
+		{"data {a=1} {b=2}", "data {a=1}", 1},
+		{`m {k="x {y=1}" z=2}`, "m", 2},
+		{"{k=v}", "", 1},
+		{"json{k=v}", "json{k=v}", 0},
+	}
+	for _, tt := range tests {
+		msg, fs := splitFields(tt.in)
+		if msg != tt.msg || len(fs) != tt.n {
+			t.Errorf("splitFields(%q) = %q, %v", tt.in, msg, fs)
+		}
+	}
+}
+
+func TestAmbiguousFields(t *testing.T) {
+	for _, wire := range []int{2, 1} {
+		var buf bytes.Buffer
+		l := mlog.New(&buf, &buf)
+		l.SetWireVersion(wire)
+		l.Info("config {a=b}")
+
+		r, err := NewDecoder(&buf).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// version 1 fields are best-effort; version 2 is exact
+		msg, n := "config {a=b}", 0
+		if wire == 1 {
+			msg, n = "config", 1
+		}
+		if r.Msg != msg || len(r.Fields) != n {
+			t.Errorf("v%d: got %q, %v", wire, r.Msg, r.Fields)
+		}
+	}
+}
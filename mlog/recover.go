@@ -0,0 +1,125 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// frames captured with a recovered panic
+const cpanicdepth = 64
+
+// A PanicAction decides what Recover does once a panic is logged.
+type PanicAction int
+
+const (
+	Repanic  PanicAction = iota // panic again with the same value
+	Exit                        // exit the process with the configured code
+	Continue                    // return normally from the deferred call
+)
+
+var panicstr = []string{"repanic", "exit", "continue"}
+
+// ParsePanicAction converts repanic, exit or continue to an action.
+func ParsePanicAction(s string) (PanicAction, error) {
+	for i, n := range panicstr {
+		if strings.EqualFold(s, n) {
+			return PanicAction(i), nil
+		}
+	}
+	return Repanic, fmt.Errorf("mlog: unknown panic action %q", s)
+}
+
+// Set what Recover does after logging a panic; code is the exit
+// status used by Exit.
+func (l *Logger) SetPanicAction(action PanicAction, code int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.panicAction, l.panicCode = action, code
+}
+
+// Recover, when deferred, recovers a panic and emits an ALARM record
+// with the panic value and the stack of the panicking goroutine. The
+// outputs are flushed and then the panic action applies:
+//
+//	defer l.Recover()
+func (l *Logger) Recover() {
+	if v := recover(); v != nil {
+		l.panicked(v)
+	}
+}
+
+// Go runs f in a new goroutine that recovers and logs its panics.
+func (l *Logger) Go(f func()) {
+	go func() {
+		defer l.Recover()
+		f()
+	}()
+}
+
+// Set what Recover does after logging a panic to the default logger.
+func SetPanicAction(action PanicAction, code int) {
+	std.SetPanicAction(action, code)
+}
+
+// Recover, when deferred, logs a panic to the default logger.
+func Recover() {
+	if v := recover(); v != nil {
+		std.panicked(v)
+	}
+}
+
+// Go runs f in a new goroutine whose panics are logged to the default
+// logger.
+func Go(f func()) {
+	std.Go(f)
+}
+
+// panicked logs v and applies the panic action
+func (l *Logger) panicked(v interface{}) {
+	st := panicStack(callers(0, cpanicdepth))
+	file, line := "???", 0
+	if len(st) > 0 {
+		file, line = st[0].File, st[0].Line
+	}
+	l.emit(ALARM, file, line, fmt.Sprintf("panic: %v", v), l.fields, st)
+	l.sync()
+
+	l.mu.Lock()
+	action, code := l.panicAction, l.panicCode
+	l.mu.Unlock()
+	switch action {
+	case Repanic:
+		panic(v)
+	case Exit:
+		os.Exit(code)
+	}
+}
+
+// panicStack drops the frames of the recovery and the runtime's panic
+// machinery, leaving the panicking function first
+func panicStack(st []Frame) []Frame {
+	for i, f := range st {
+		if f.Function != "runtime.gopanic" {
+			continue
+		}
+		for i++; i < len(st) && strings.HasPrefix(st[i].Function, "runtime."); i++ {
+		}
+		return st[i:]
+	}
+	return st
+}
+
+// sync writes queued records and commits the outputs that support it,
+// such as files, and sinks holding records, which send them
+func (l *Logger) sync() {
+	l.Flush()
+	for _, w := range l.outputs() {
+		if s, ok := w.(interface{ Sync() error }); ok {
+			s.Sync()
+		}
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	l.SetCorelationId("c1")
+	l.SetPanicAction(Continue, 0)
+
+	func() {
+		defer l.Recover()
+		var m map[string]int
+		m["x"] = 1
+	}()
+	got := out.String()
+	if !strings.HasPrefix(got, "*2|") || !strings.Contains(got, "|ALARM|c1|") ||
+		!strings.Contains(got, "|recover_test.go:") || !strings.Contains(got, "panic: assignment to entry in nil map") ||
+		!strings.Contains(got, "|stack=github.com/lavaorg/lrt/mlog.TestRecover.func1 ") {
+		t.Errorf("output = %q", got)
+	}
+
+	out.Reset()
+	done := make(chan bool, 1)
+	l.SetPanicAction(Repanic, 0)
+	func() {
+		defer func() { done <- recover() == "boom" }()
+		defer l.Recover()
+		panic("boom")
+	}()
+	if !<-done || !strings.Contains(out.String(), "panic: boom") {
+		t.Errorf("not re-panicked, output = %q", out.String())
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A Sink receives the records routed to it, each with its text as
+// encoded in the Logger's format. The text is only valid during the
+// call. Calls are serialized by the Logger. A Sink that is an io.Closer
+// is closed by Logger.Close. Sinks are compared with ==, so an
+// implementation is normally a pointer.
+type Sink interface {
+	Write(r *Record, text []byte) error
+}
+
+// WriterSink returns a Sink writing the text of each record to w.
+func WriterSink(w io.Writer) Sink {
+	return &writerSink{w}
+}
+
+type writerSink struct {
+	w io.Writer
+}
+
+func (s *writerSink) Write(r *Record, text []byte) error {
+	_, err := s.w.Write(text)
+	return err
+}
+
+// streams returns the default routing: ALARM, ERROR, STAT and EVENT to
+// stderr, the rest to stdout
+func streams(stdout, stderr io.Writer) (routes [UNKNOWN + 1][]Sink) {
+	out, err := WriterSink(stdout), WriterSink(stderr)
+	if stdout == stderr {
+		err = out
+	}
+	for sev := range routes {
+		if uint8(sev) > EVENT {
+			routes[sev] = []Sink{out}
+		} else {
+			routes[sev] = []Sink{err}
+		}
+	}
+	return routes
+}
+
+// Route records of the given severity to the sinks, replacing its
+// current routing; no sinks discards them. SetOutput restores the
+// default routing.
+func (l *Logger) SetRoute(sev uint8, sinks ...Sink) {
+	if sev > UNKNOWN {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.routes[sev] = append([]Sink(nil), sinks...)
+}
+
+// Set the routing from a spec of comma separated SEVERITIES=SINKS rules,
+// e.g. "*=stdout" or "*=stderr,STAT=file:/var/log/app/stat.log". The
+// severities are a name, a range such as ALARM-EVENT or * for all. The
+// sinks are joined by + and are one of stdout and stderr, the logger's
+// two streams, discard, file:PATH, a RotatingFile, syslog:, syslog:PATH
+// or syslog:NETWORK://ADDRESS, a SyslogSink, journald: or journald:PATH,
+// a JournalSink, tcp:HOST:PORT or tls:HOST:PORT, a NetworkSink, or
+// otlp:URL, an OTLPSink. Later rules replace earlier ones; severities not
+// named keep their routing.
+func (l *Logger) SetRoutes(spec string) error {
+	l.mu.Lock()
+	routes := l.routes
+	stdout, stderr := l.stdout, l.stderr
+	l.mu.Unlock()
+
+	opened := map[string]Sink{
+		"stdout":  WriterSink(stdout),
+		"stderr":  WriterSink(stderr),
+		"discard": nil,
+	}
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		eq := strings.IndexByte(rule, '=')
+		if eq < 0 {
+			return fmt.Errorf("mlog: bad route %q", rule)
+		}
+		lo, hi, err := parseSeverities(rule[:eq])
+		if err != nil {
+			return err
+		}
+		var sinks []Sink
+		for _, dest := range strings.Split(rule[eq+1:], "+") {
+			s, ok := opened[dest]
+			if !ok {
+				if s, err = openSink(dest); err != nil {
+					return err
+				}
+				opened[dest] = s
+			}
+			if s != nil {
+				sinks = append(sinks, s)
+			}
+		}
+		for sev := lo; sev <= hi; sev++ {
+			routes[sev] = sinks
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.routes = routes
+	return nil
+}
+
+// Route records of the default logger.
+func SetRoute(sev uint8, sinks ...Sink) {
+	std.SetRoute(sev, sinks...)
+}
+
+// Set the routing of the default logger from a spec.
+func SetRoutes(spec string) error {
+	return std.SetRoutes(spec)
+}
+
+// parseSeverities parses *, SEV or SEV-SEV
+func parseSeverities(s string) (uint8, uint8, error) {
+	if s == "*" {
+		return ALARM, UNKNOWN, nil
+	}
+	lo, hi := s, s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		lo, hi = s[:i], s[i+1:]
+	}
+	a, err := ParseSeverity(lo)
+	if err != nil {
+		return 0, 0, err
+	}
+	b, err := ParseSeverity(hi)
+	if err != nil {
+		return 0, 0, err
+	}
+	if a > b {
+		a, b = b, a
+	}
+	return a, b, nil
+}
+
+// sinkOpeners create the sinks named in a route by a scheme and argument,
+// e.g. file:PATH
+var sinkOpeners = map[string]func(arg string) (Sink, error){
+	"file": func(path string) (Sink, error) {
+		return WriterSink(&RotatingFile{
+			Path:       path,
+			MaxSize:    ext.MlogFileMaxSize,
+			MaxAge:     ext.MlogFileMaxAge,
+			MaxBackups: ext.MlogFileBackups,
+			Compress:   ext.MlogFileCompress,
+		}), nil
+	},
+	"syslog": openSyslog,
+	"journald": func(path string) (Sink, error) {
+		return &JournalSink{Path: path}, nil
+	},
+	"tcp": func(addr string) (Sink, error) {
+		return openNetwork("tcp", addr)
+	},
+	"tls": func(addr string) (Sink, error) {
+		return openNetwork("tls", addr)
+	},
+	"otlp": openOTLP,
+}
+
+func openSink(dest string) (Sink, error) {
+	scheme, arg, _ := strings.Cut(dest, ":")
+	open, ok := sinkOpeners[scheme]
+	if !ok {
+		return nil, fmt.Errorf("mlog: unknown sink %q", dest)
+	}
+	return open(arg)
+}
+
+// outputs returns the streams and routed sinks, once each, with the
+// writer of a WriterSink in its place
+func (l *Logger) outputs() []interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	outs := []interface{}{l.stdout}
+	add := func(o interface{}) {
+		for _, x := range outs {
+			if x == o {
+				return
+			}
+		}
+		outs = append(outs, o)
+	}
+	add(l.stderr)
+	for _, sinks := range l.routes {
+		for _, s := range sinks {
+			if ws, ok := s.(*writerSink); ok {
+				add(ws.w)
+			} else {
+				add(s)
+			}
+		}
+	}
+	return outs
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	l := New(&stdout, &stderr)
+	l.SetSuppress(true)
+
+	stat := filepath.Join(t.TempDir(), "stat.log")
+	if err := l.SetRoutes("*=stdout, STAT=file:" + stat + "+stderr, DEBUG=discard"); err != nil {
+		t.Fatal(err)
+	}
+	l.SetLevel(DEBUG)
+	l.Error("e")
+	l.Stat("s")
+	l.Debug("d")
+	l.Info("i")
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := stdout.String(), "*2|7|ERROR||||||e\n*2|7|INFO||||||i\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if got, want := stderr.String(), "*2|7|STAT||||||s\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+	if b, err := os.ReadFile(stat); err != nil || string(b) != "*2|7|STAT||||||s\n" {
+		t.Errorf("stat file = %q, %v", b, err)
+	}
+
+	for _, spec := range []string{"INFO", "LOUD=stdout", "*=nowhere", "INFO=bogus:x"} {
+		if err := l.SetRoutes(spec); err == nil {
+			t.Errorf("SetRoutes(%q) accepted", spec)
+		}
+	}
+	if lo, hi, err := parseSeverities("EVENT-ERROR"); err != nil || lo != ERROR || hi != EVENT {
+		t.Errorf("parseSeverities = %d, %d, %v", lo, hi, err)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// LevelAlarm is the slog level at and above which records are ALARMs.
+const LevelAlarm = slog.LevelError + 4
+
+// A Handler is a slog.Handler writing mlog records through a Logger.
+// Levels map to severities: below slog.LevelInfo is DEBUG, below
+// slog.LevelWarn INFO, below slog.LevelError EVENT, below LevelAlarm
+// ERROR and the rest ALARM. Attributes become fields; those in groups
+// have keys qualified by the group names, e.g. "req.method". The
+// correlation id of the context is used if it carries one. Every mlog
+// record has a time, so unlike the slog convention a record with a zero
+// Time is not written without one; it is stamped when handled.
+type Handler struct {
+	l      *Logger
+	fields []Field // from WithAttrs
+	prefix string  // from WithGroup, "" or ending in "."
+}
+
+// NewHandler returns a Handler writing through l, or the default
+// Logger if l is nil. The default slog Logger uses such a Handler.
+func NewHandler(l *Logger) *Handler {
+	if l == nil {
+		l = std
+	}
+	return &Handler{l: l}
+}
+
+// Severity returns the mlog severity of a slog level.
+func Severity(level slog.Level) uint8 {
+	switch {
+	case level < slog.LevelInfo:
+		return DEBUG
+	case level < slog.LevelWarn:
+		return INFO
+	case level < slog.LevelError:
+		return EVENT
+	case level < LevelAlarm:
+		return ERROR
+	}
+	return ALARM
+}
+
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.l.possible(Severity(level))
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	sev := Severity(r.Level)
+	file, line := "???", 0
+	if r.PC != 0 {
+		f, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		file, line = f.File, f.Line
+	}
+	if !h.l.allowed(sev, r.PC, file) {
+		return nil
+	}
+
+	fs := make([]Field, 0, len(h.l.fields)+len(h.fields)+r.NumAttrs())
+	fs = append(append(fs, h.l.fields...), h.fields...)
+	r.Attrs(func(a slog.Attr) bool {
+		fs = appendAttr(fs, h.prefix, a)
+		return true
+	})
+
+	l := h.l
+	if ctx != nil {
+		l = l.WithContext(ctx)
+	}
+	rec := l.record(sev, file, line, r.Message, fs)
+	if !r.Time.IsZero() {
+		rec.Time = r.Time
+	}
+	if depth := atomic.LoadInt32(&l.stack[sev]); depth > 0 && r.PC != 0 {
+		rec.Stack = callersFrom(r.PC, int(depth))
+	}
+	l.send(rec)
+	return nil
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := *h
+	c.fields = append([]Field(nil), h.fields...)
+	for _, a := range attrs {
+		c.fields = appendAttr(c.fields, h.prefix, a)
+	}
+	return &c
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := *h
+	c.prefix = h.prefix + name + "."
+	return &c
+}
+
+// appendAttr appends a as fields, flattening groups
+func appendAttr(fs []Field, prefix string, a slog.Attr) []Field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fs
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, g := range a.Value.Group() {
+			fs = appendAttr(fs, prefix, g)
+		}
+		return fs
+	}
+	var v interface{} = a.Value.Any()
+	if a.Value.Kind() == slog.KindTime {
+		v = a.Value.Time().Format(time.RFC3339Nano)
+	}
+	return append(fs, Field{Key: prefix + a.Key, Value: v})
+}
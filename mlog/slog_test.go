@@ -0,0 +1,110 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"testing/slogtest"
+)
+
+func TestSlogHandler(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	l.SetLevel(INFO)
+	sl := slog.New(NewHandler(l)).With("svc", "api").WithGroup("req")
+
+	sl.Debug("hidden")
+	ctx := WithCorelationId(context.Background(), "r1")
+	sl.WarnContext(ctx, "slow", "ms", 30, slog.Group("user", "id", 7), slog.Group("empty"))
+	sl.Log(ctx, LevelAlarm, "down")
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("output = %q", out.String())
+	}
+	if !strings.Contains(lines[0], "|EVENT|r1|") || !strings.Contains(lines[0], "|slog_test.go:") ||
+		!strings.HasSuffix(lines[0], "|slow|svc=api|req.ms=30|req.user.id=7") {
+		t.Errorf("line 0 = %q", lines[0])
+	}
+	if !strings.Contains(lines[1], "|ALARM|r1|") {
+		t.Errorf("line 1 = %q", lines[1])
+	}
+
+	for level, sev := range map[slog.Level]uint8{
+		slog.LevelDebug: DEBUG, slog.LevelInfo: INFO, slog.LevelWarn: EVENT,
+		slog.LevelError: ERROR, slog.LevelError + 1: ERROR, LevelAlarm: ALARM,
+	} {
+		if got := Severity(level); got != sev {
+			t.Errorf("Severity(%v) = %s, want %s", level, sevstr[got], sevstr[sev])
+		}
+	}
+}
+
+func TestSlogStack(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	l.SetFormat(FormatJSON)
+	l.SetStack(ERROR, 2)
+	slog.New(NewHandler(l)).Error("failed")
+
+	var rec struct {
+		Stack []Frame `json:"stack"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Stack) != 2 || rec.Stack[0].Function != "github.com/lavaorg/lrt/mlog.TestSlogStack" ||
+		!strings.HasSuffix(rec.Stack[0].File, "slog_test.go") {
+		t.Errorf("stack = %+v", rec.Stack)
+	}
+}
+
+func TestSlogConformance(t *testing.T) {
+	var out bytes.Buffer
+	newHandler := func(t *testing.T) slog.Handler {
+		if strings.HasSuffix(t.Name(), "/zero-time") {
+			t.Skip("mlog records always have a time")
+		}
+		out.Reset()
+		l := New(&out, &out)
+		l.SetFormat(FormatJSON)
+		return NewHandler(l)
+	}
+	// result maps a JSON record to the keys slogtest expects, nesting
+	// the fields of groups and leaving out an unknown source
+	result := func(t *testing.T) map[string]interface{} {
+		var r struct {
+			Sev, Time, Msg, File string
+			Line                 int
+			Fields               map[string]interface{}
+		}
+		if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+			t.Fatalf("%v: %q", err, out.String())
+		}
+		m := map[string]interface{}{slog.LevelKey: r.Sev, slog.TimeKey: r.Time, slog.MessageKey: r.Msg}
+		if r.File != "???" {
+			m[slog.SourceKey] = r.File
+		}
+		for k, v := range r.Fields {
+			g := m
+			keys := strings.Split(k, ".")
+			for _, key := range keys[:len(keys)-1] {
+				sub, ok := g[key].(map[string]interface{})
+				if !ok {
+					sub = map[string]interface{}{}
+					g[key] = sub
+				}
+				g = sub
+			}
+			g[keys[len(keys)-1]] = v
+		}
+		return m
+	}
+	slogtest.Run(t, newHandler, result)
+}
@@ -0,0 +1,122 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// key of the field carrying a stack in the mlog and logfmt formats
+const cstackkey = "stack"
+
+// A Frame is one call of a stack captured with a record.
+type Frame struct {
+	Function string `json:"func"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+// Capture the calling stack, at most depth frames, with every record of
+// the given severity; 0 stops capturing. Intended for ERROR and ALARM.
+// The mlog and logfmt formats add the stack as a field named "stack"
+// holding a line per frame, JSON as an array of frames.
+func (l *Logger) SetStack(sev uint8, depth int) {
+	if sev > UNKNOWN {
+		return
+	}
+	if depth < 0 {
+		depth = 0
+	}
+	atomic.StoreInt32(&l.stack[sev], int32(depth))
+}
+
+// Capture stacks with records of the default logger.
+func SetStack(sev uint8, depth int) {
+	std.SetStack(sev, depth)
+}
+
+// parseStack parses a list of SEV=DEPTH pairs, e.g. ALARM=32,ERROR=16
+func parseStack(spec string) ([UNKNOWN + 1]int, error) {
+	var depths [UNKNOWN + 1]int
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return depths, fmt.Errorf("mlog: bad stack setting %q", s)
+		}
+		sev, err := ParseSeverity(s[:eq])
+		if err != nil {
+			return depths, err
+		}
+		n, err := strconv.Atoi(s[eq+1:])
+		if err != nil || n < 0 {
+			return depths, fmt.Errorf("mlog: bad stack depth %q", s)
+		}
+		depths[sev] = n
+	}
+	return depths, nil
+}
+
+// callers captures up to depth frames starting skip frames above the
+// caller, counted as for runtime.Caller
+func callers(skip, depth int) []Frame {
+	pcs := make([]uintptr, depth)
+	n := runtime.Callers(skip+2, pcs)
+	return frames(pcs[:n])
+}
+
+// callersFrom captures up to depth frames starting at pc, a return
+// address in the calling stack such as slog.Record.PC; if pc is not
+// found, as when a record is handled on another goroutine, only its
+// own frame is returned
+func callersFrom(pc uintptr, depth int) []Frame {
+	pcs := make([]uintptr, depth+64)
+	n := runtime.Callers(2, pcs)
+	for i, p := range pcs[:n] {
+		if p == pc {
+			return frames(pcs[i:min(n, i+depth)])
+		}
+	}
+	return frames([]uintptr{pc})
+}
+
+// frames resolves pcs as returned by runtime.Callers
+func frames(pcs []uintptr) []Frame {
+	frames := runtime.CallersFrames(pcs)
+	st := make([]Frame, 0, len(pcs))
+	for {
+		f, more := frames.Next()
+		st = append(st, Frame{Function: f.Function, File: f.File, Line: f.Line})
+		if !more {
+			return st
+		}
+	}
+}
+
+// formatStack renders a line per frame: "function file:line"
+func formatStack(st []Frame) string {
+	var b strings.Builder
+	for i, f := range st {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		b.WriteString(f.Function + " " + f.File + ":" + strconv.Itoa(f.Line))
+	}
+	return b.String()
+}
+
+// stackFields returns the record's fields plus its stack, if any
+func stackFields(r *Record) []Field {
+	if len(r.Stack) == 0 {
+		return r.Fields
+	}
+	return append(r.Fields[:len(r.Fields):len(r.Fields)], Field{Key: cstackkey, Value: formatStack(r.Stack)})
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestStack(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	l.SetSuppress(true)
+	l.SetStack(ERROR, 2)
+
+	l.Info("no stack")
+	if strings.Contains(out.String(), "stack=") {
+		t.Errorf("INFO carried a stack: %q", out.String())
+	}
+
+	out.Reset()
+	l.ErrorKV("failed", "n", 1)
+	got := out.String()
+	if !strings.HasPrefix(got, "*2|9|ERROR||||||failed|n=1|stack=github.com/lavaorg/lrt/mlog.TestStack ") ||
+		strings.Count(got, `\n`) != 1 {
+		t.Errorf("output = %q", got)
+	}
+
+	out.Reset()
+	l.SetFormat(FormatJSON)
+	l.Error("failed")
+	var rec struct {
+		Stack []Frame `json:"stack"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &rec); err != nil {
+		t.Fatal(err)
+	}
+	if len(rec.Stack) != 2 || !strings.HasSuffix(rec.Stack[0].File, "stack_test.go") || rec.Stack[0].Line == 0 {
+		t.Errorf("stack = %+v", rec.Stack)
+	}
+
+	if d, err := parseStack("alarm=32, ERROR=8"); err != nil || d[ALARM] != 32 || d[ERROR] != 8 {
+		t.Errorf("parseStack = %v, %v", d, err)
+	}
+	if _, err := parseStack("ERROR"); err == nil {
+		t.Error("parseStack accepted a missing depth")
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"io"
+	"net"
+	"time"
+)
+
+// a write to a stream connection is taken as delivered once the
+// connection has stayed open this long after it; a peer that closes
+// is seen within a round trip
+const cdelivered = 500 * time.Millisecond
+
+// watchStream reads c, which the peer never writes to, in the
+// background until it is closed, then calls closed. Writes into a
+// connection the peer has closed still succeed, so this is how the
+// stream sinks notice it and resend what was written just before.
+func watchStream(c net.Conn, closed func()) {
+	go func() {
+		io.Copy(io.Discard, c)
+		closed()
+	}()
+}
@@ -0,0 +1,275 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syslog message constants
+const (
+	csyslogpath   = "/dev/log"
+	csyslogsdid   = "mlog@32473" // SD-ID of the record's structured data
+	csyslogtime   = "2006-01-02T15:04:05.000000Z07:00"
+	csyslogdial   = 5 * time.Second
+	csyslogresend = 1024 // messages kept for resending
+	cfacilityuser = 1
+)
+
+// syslog severity of each mlog severity
+var syslogsev = [UNKNOWN + 1]int{
+	ALARM:   2, // crit
+	ERROR:   3, // err
+	STAT:    5, // notice
+	EVENT:   5, // notice
+	INFO:    6, // info
+	DEBUG:   7, // debug
+	UNKNOWN: 5,
+}
+
+// A SyslogSink is a Sink sending RFC 5424 messages to a syslog daemon.
+// Network is unixgram, unix, udp or tcp and Addr the socket path or
+// host:port; both empty use /dev/log, and an empty Network with a path
+// tries unixgram then unix. The correlation id, source and fields are
+// carried as structured data. Messages on stream sockets are framed by
+// octet counting (RFC 6587). The connection is made on the first write
+// and remade once when a write fails. A stream connection is read to
+// notice the daemon closing it, say on a restart, as writes into it
+// still succeed; it is then remade and the messages written to it in
+// the last moments before are sent again, so a message may arrive
+// twice. The zero value with the Facility defaulting to user is ready
+// for use.
+type SyslogSink struct {
+	Network  string
+	Addr     string
+	Facility int    // 0 uses user (1)
+	Hostname string // "" uses os.Hostname
+	AppName  string // "" uses the record's process name
+
+	mu     sync.Mutex
+	conn   net.Conn
+	stream bool      // conn needs framing
+	recent []written // on a stream, possibly not delivered
+}
+
+// a message and when it was written
+type written struct {
+	at  time.Time
+	msg []byte
+}
+
+// Write sends the record; text is not used.
+func (s *SyslogSink) Write(r *Record, text []byte) error {
+	msg := s.format(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for try := 0; try < 2; try++ {
+		if s.conn == nil {
+			if err = s.connect(); err != nil {
+				return err
+			}
+		}
+		if err = s.send(msg); err == nil {
+			s.remember(msg)
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+// send writes a message, framed on streams
+func (s *SyslogSink) send(msg []byte) error {
+	var err error
+	if s.stream {
+		_, err = s.conn.Write(append([]byte(strconv.Itoa(len(msg))+" "), msg...))
+	} else {
+		_, err = s.conn.Write(msg)
+	}
+	return err
+}
+
+// remember keeps a message written to a stream until it is taken as
+// delivered
+func (s *SyslogSink) remember(msg []byte) {
+	if !s.stream {
+		return
+	}
+	now := time.Now()
+	i := 0
+	for i < len(s.recent) && (now.Sub(s.recent[i].at) >= cdelivered || len(s.recent)-i >= csyslogresend) {
+		i++
+	}
+	s.recent = append(s.recent[i:], written{now, msg})
+}
+
+// connect dials and resends the messages the last connection may have
+// lost
+func (s *SyslogSink) connect() error {
+	if err := s.dial(); err != nil {
+		return err
+	}
+	now := time.Now()
+	for i := range s.recent {
+		if err := s.send(s.recent[i].msg); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+		s.recent[i].at = now
+	}
+	return nil
+}
+
+// lost is called when c is closed; if that was the daemon it
+// reconnects to resend the recent messages
+func (s *SyslogSink) lost(c net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != c {
+		return
+	}
+	c.Close()
+	s.conn = nil
+	if len(s.recent) > 0 {
+		s.connect()
+	}
+}
+
+// Close closes the connection.
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	s.recent = nil
+	return err
+}
+
+func (s *SyslogSink) dial() error {
+	network, addr := s.Network, s.Addr
+	if addr == "" {
+		addr = csyslogpath
+	}
+	networks := []string{network}
+	if network == "" {
+		networks = []string{"unixgram", "unix"}
+	}
+	var err error
+	for _, n := range networks {
+		var c net.Conn
+		if c, err = net.DialTimeout(n, addr, csyslogdial); err == nil {
+			s.conn, s.stream = c, n == "tcp" || n == "unix"
+			if s.stream {
+				watchStream(c, func() { s.lost(c) })
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+// format renders r as an RFC 5424 message
+func (s *SyslogSink) format(r *Record) []byte {
+	facility := s.Facility
+	if facility == 0 {
+		facility = cfacilityuser
+	}
+	host := s.Hostname
+	if host == "" {
+		host, _ = os.Hostname()
+	}
+	app := s.AppName
+	if app == "" {
+		app = r.Name
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s [%s",
+		facility*8+syslogsev[min(r.Sev, UNKNOWN)],
+		r.Time.UTC().Format(csyslogtime),
+		syslogName(host, 255),
+		syslogName(app, 48),
+		syslogName(r.Pid, 128),
+		syslogName(sevstr[min(r.Sev, UNKNOWN)], 32),
+		csyslogsdid)
+	sdParam(&b, "corr", r.CorelationId)
+	sdParam(&b, "file", r.File+":"+strconv.Itoa(r.Line))
+	for _, f := range stackFields(r) {
+		sdParam(&b, f.Key, fmt.Sprint(f.Value))
+	}
+	b.WriteString("] ")
+	b.WriteString(r.Msg)
+	return []byte(b.String())
+}
+
+// syslogName makes s a header field: printable ASCII without spaces,
+// at most n long, "-" if empty
+func syslogName(s string, n int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > n {
+		s = s[:n]
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// sdParam writes a structured data parameter, making the name valid and
+// escaping the value
+func sdParam(b *strings.Builder, name, value string) {
+	name = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	if name == "" {
+		name = "_"
+	}
+	b.WriteString(" " + name + `="`)
+	for _, r := range value {
+		if r == '"' || r == '\\' || r == ']' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+}
+
+// openSyslog opens a route's syslog sink: syslog: for /dev/log,
+// syslog:PATH or syslog:NETWORK://ADDRESS
+func openSyslog(arg string) (Sink, error) {
+	network, addr, ok := strings.Cut(arg, "://")
+	if !ok {
+		network, addr = "", arg
+	}
+	switch network {
+	case "", "unix", "unixgram", "udp", "tcp":
+	default:
+		return nil, fmt.Errorf("mlog: unknown syslog network %q", network)
+	}
+	return &SyslogSink{Network: network, Addr: addr}, nil
+}
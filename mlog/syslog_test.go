@@ -0,0 +1,89 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSyslogUnixgram(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	pc, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+
+	l := New(nil, nil)
+	l.SetCorelationId("c1")
+	s, err := openSyslog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetRoute(ERROR, s)
+	s.(*SyslogSink).Hostname = "h"
+	l.ErrorKV("disk full", "dev", `sd"a]`)
+	defer l.Close()
+
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(`^<11>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}Z h \S+ \d+ ERROR ` +
+		`\[mlog@32473 corr="c1" file="syslog_test.go:\d+" dev="sd\\"a\\]"\] disk full$`)
+	if got := string(buf[:n]); !re.MatchString(got) {
+		t.Errorf("message = %q", got)
+	}
+}
+
+func TestSyslogTCPReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer ln.Close()
+
+	s := &SyslogSink{Network: "tcp", Addr: ln.Addr().String(), Facility: 16}
+	defer s.Close()
+	l := New(nil, nil)
+	l.SetRoute(ALARM, s)
+
+	for i := 0; i < 3; i++ {
+		want := "] down " + strconv.Itoa(i)
+		l.Alarm("down %d", i)
+		c, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// messages sent just before a restart may come again first
+		r := bufio.NewReader(c)
+		for {
+			size, err := r.ReadString(' ')
+			if err != nil {
+				t.Fatalf("reading %q: %v", want, err)
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(size))
+			msg := make([]byte, n)
+			if _, err := io.ReadFull(r, msg); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(msg), "<130>1 ") {
+				t.Errorf("message = %q", msg)
+			}
+			if strings.HasSuffix(string(msg), want) {
+				break
+			}
+		}
+		// the daemon restarts; the next message must not be lost
+		c.Close()
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"fmt"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// vmodule holds per source file / package level rules, for example
+//
+//	db*=DEBUG,http/server.go=INFO
+//
+// A pattern containing ".go" is matched against the trailing elements of
+// the caller's source file path, any other pattern against the trailing
+// elements of the caller's package import path. Patterns use path.Match
+// syntax. The first matching rule sets the level for that caller,
+// overriding the logger's level.
+type vmodule struct {
+	rules []vrule
+	max   uint8    // least severe level of any rule
+	cache sync.Map // pc -> vresult
+}
+
+type vrule struct {
+	pattern string
+	file    bool // match the file rather than the package
+	depth   int  // number of path elements in pattern
+	level   uint8
+}
+
+type vresult struct {
+	level   uint8
+	matched bool
+}
+
+// parseVmodule parses a comma separated list of pattern=SEV rules
+func parseVmodule(spec string) (*vmodule, error) {
+	vm := &vmodule{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		eq := strings.LastIndex(item, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("mlog: bad vmodule rule %q", item)
+		}
+		pat := item[:eq]
+		if _, err := path.Match(pat, ""); err != nil {
+			return nil, fmt.Errorf("mlog: bad vmodule pattern %q", pat)
+		}
+		lev, err := ParseSeverity(item[eq+1:])
+		if err != nil {
+			return nil, err
+		}
+		vm.rules = append(vm.rules, vrule{
+			pattern: pat,
+			file:    strings.Contains(pat, ".go"),
+			depth:   strings.Count(pat, "/") + 1,
+			level:   lev,
+		})
+		if lev > vm.max {
+			vm.max = lev
+		}
+	}
+	if len(vm.rules) == 0 {
+		return nil, nil
+	}
+	return vm, nil
+}
+
+// level returns the level set by the first rule matching the caller
+func (vm *vmodule) level(pc uintptr, file string) (uint8, bool) {
+	if r, ok := vm.cache.Load(pc); ok {
+		v := r.(vresult)
+		return v.level, v.matched
+	}
+	var pkg string
+	if fn := runtime.FuncForPC(pc); fn != nil {
+		pkg = funcPackage(fn.Name())
+	}
+	var v vresult
+	for _, r := range vm.rules {
+		subject := pkg
+		if r.file {
+			subject = file
+		}
+		if ok, _ := path.Match(r.pattern, trailing(subject, r.depth)); ok {
+			v = vresult{level: r.level, matched: true}
+			break
+		}
+	}
+	vm.cache.Store(pc, v)
+	return v.level, v.matched
+}
+
+// funcPackage returns the import path of a function name such as
+// github.com/lavaorg/lrt/mlog.(*Logger).Info
+func funcPackage(fn string) string {
+	slash := strings.LastIndex(fn, "/")
+	if dot := strings.Index(fn[slash+1:], "."); dot >= 0 {
+		return fn[:slash+1+dot]
+	}
+	return fn
+}
+
+// trailing returns the last n slash separated elements of p
+func trailing(p string, n int) string {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i] == '/' {
+			n--
+			if n == 0 {
+				return p[i+1:]
+			}
+		}
+	}
+	return p
+}
+
+// SetVmodule replaces the per file / package level rules; an empty
+// spec removes them. See LRT_MLOGVMODULE.
+func (l *Logger) SetVmodule(spec string) error {
+	vm, err := parseVmodule(spec)
+	if err != nil {
+		return err
+	}
+	l.vmodule.Store(vm)
+	return nil
+}
+
+// SetVmodule replaces the level rules of the default logger.
+func SetVmodule(spec string) error {
+	return std.SetVmodule(spec)
+}
+
+// possible reports whether some caller may emit records of sev
+func (l *Logger) possible(sev uint8) bool {
+	if l.Enabled(sev) {
+		return true
+	}
+	vm := l.vmodule.Load()
+	return vm != nil && sev <= vm.max
+}
+
+// allowed reports whether the caller at pc in file may emit sev
+func (l *Logger) allowed(sev uint8, pc uintptr, file string) bool {
+	if vm := l.vmodule.Load(); vm != nil {
+		if lev, ok := vm.level(pc, file); ok {
+			return sev <= lev || sev == UNKNOWN
+		}
+	}
+	return l.Enabled(sev)
+}
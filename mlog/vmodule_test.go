@@ -0,0 +1,56 @@
+// Copyright 2018 Larry Rau. All rights reserved
+// See Apache2 LICENSE
+
+package mlog
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVmodule(t *testing.T) {
+	var out bytes.Buffer
+	l := New(&out, &out)
+	l.SetSuppress(true)
+	l.SetLevel(ERROR)
+
+	if err := l.SetVmodule("db*=DEBUG,x=LOUD"); err == nil {
+		t.Error("accepted a bad severity")
+	}
+	if err := l.SetVmodule("vmodule_test.go=DEBUG"); err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("file")
+	if err := l.SetVmodule("other.go=DEBUG,lrt/mlog=STAT"); err != nil {
+		t.Fatal(err)
+	}
+	l.Debug("no")
+	l.Stat("package")
+	if err := l.SetVmodule("ml*=ALARM"); err != nil {
+		t.Fatal(err)
+	}
+	l.Error("no")
+	l.SetVmodule("")
+	l.Error("global")
+
+	want := "*2|7|DEBUG||||||file\n*2|7|STAT||||||package\n*2|7|ERROR||||||global\n"
+	if got := out.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestFuncPackage(t *testing.T) {
+	tests := []struct{ fn, pkg string }{
+		{"github.com/lavaorg/lrt/mlog.(*Logger).Info", "github.com/lavaorg/lrt/mlog"},
+		{"main.main", "main"},
+		{"net/http.(*conn).serve.func1", "net/http"},
+	}
+	for _, tt := range tests {
+		if got := funcPackage(tt.fn); got != tt.pkg {
+			t.Errorf("funcPackage(%q) = %q, want %q", tt.fn, got, tt.pkg)
+		}
+	}
+	if got := trailing("/src/http/server.go", 2); got != "http/server.go" {
+		t.Errorf("trailing = %q", got)
+	}
+}